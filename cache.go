@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// =============================================================================
+// Metadata Cache
+// =============================================================================
+
+// cacheEntry holds metadata extracted from one file. It is valid only while
+// the file's size, modification time and inode match.
+type cacheEntry struct {
+	Path        string       // Cache key (see libraryRelPath)
+	Size        int64        // File size when extracted
+	ModTime     time.Time    // File modification time when extracted
+	Inode       uint64       // File inode when extracted (0 where unsupported)
+	CaptureDate time.Time    // Extracted capture date
+	DateSource  string       // Where CaptureDate came from; "" if not extracted
+	Camera      cameraInfo   // EXIF camera, lens and exposure
+	GPS         *gpsPosition // EXIF GPS position; nil if none
+	Video       videoInfo    // Container duration, frame rate and codec
+	Hash        string       // MD5 of the first 64KB; "" if not computed
+	SHA256      string       // Full-content SHA-256; "" if not computed
+}
+
+// matches returns true if the entry still describes the file.
+func (e *cacheEntry) matches(info os.FileInfo) bool {
+	return e.Size == info.Size() && e.ModTime.Equal(info.ModTime()) && e.Inode == fileInode(info)
+}
+
+// metadataCache is a persistent cache of extracted metadata in _Manifest/,
+// so repeated runs over the same files skip EXIF decoding and hashing.
+// A nil cache is valid and caches nothing. Safe for concurrent use.
+type metadataCache struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]*cacheEntry
+	dirty   bool
+}
+
+// metaCache is the cache used by getFileHash and the pipeline.
+// Nil until loaded (or when disabled with --no-cache).
+var metaCache *metadataCache
+
+// cacheHeaders are the CSV columns of the cache file.
+var cacheHeaders = []string{
+	"path",
+	"file_size_bytes",
+	"file_modified",
+	"inode",
+	"capture_date",
+	"date_source",
+	"camera_make",
+	"camera_model",
+	"file_hash",
+	"sha256",
+	"camera_serial",
+	"lens_model",
+	"focal_length_mm",
+	"f_number",
+	"shutter_speed",
+	"iso",
+	"orientation",
+	"width_px",
+	"height_px",
+	"gps_latitude",
+	"gps_longitude",
+	"gps_altitude_m",
+	"duration_s",
+	"frame_rate",
+	"video_codec",
+}
+
+// cacheBaseColumns is the number of columns in caches written before the
+// lens, exposure, GPS and video columns were added. Rows with fewer columns
+// than cacheHeaders keep their hashes but have their metadata extracted
+// again.
+const cacheBaseColumns = 10
+
+// loadMetadataCache reads the cache file at path.
+// A missing or unreadable cache yields an empty one; it is only a cache.
+func loadMetadataCache(path string) *metadataCache {
+	c := &metadataCache{path: path, entries: make(map[string]*cacheEntry)}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return c
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		fmt.Printf("Warning: ignoring unreadable metadata cache %s: %v\n", path, err)
+		c.dirty = true // Rewrite it on save
+		return c
+	}
+
+	for i, row := range records {
+		if i == 0 || len(row) < cacheBaseColumns {
+			continue // Header or malformed row
+		}
+		e := &cacheEntry{
+			Path:       row[0],
+			DateSource: row[5],
+			Hash:       row[8],
+			SHA256:     row[9],
+		}
+		if len(row) < len(cacheHeaders) {
+			e.DateSource = "" // Older cache without every EXIF column
+		} else {
+			extra := row[cacheBaseColumns:]
+			n := len(cameraColumns) - 2 // Make and model are base columns
+			e.Camera = parseCameraInfo(append([]string{row[6], row[7]}, extra[:n]...))
+			extra = extra[n:]
+			e.GPS = parseGPSPosition(extra[:len(gpsColumns)])
+			e.Video = parseVideoInfo(extra[len(gpsColumns):])
+		}
+		e.Size, _ = strconv.ParseInt(row[1], 10, 64)
+		e.ModTime, _ = time.Parse(time.RFC3339Nano, row[2])
+		e.Inode, _ = strconv.ParseUint(row[3], 10, 64)
+		e.CaptureDate, _ = time.Parse(time.RFC3339Nano, row[4])
+		if filepath.IsAbs(filepath.FromSlash(e.Path)) {
+			// Keyed by absolute path by older versions run with a relative
+			// --root; rekey so the cache survives the library moving
+			e.Path = libraryRelPath(filepath.FromSlash(e.Path))
+			c.dirty = true
+		}
+		c.entries[e.Path] = e
+	}
+
+	return c
+}
+
+// lookup returns a copy of the cache entry for path if it still matches info.
+func (c *metadataCache) lookup(path string, info os.FileInfo) (cacheEntry, bool) {
+	if c == nil {
+		return cacheEntry{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[libraryRelPath(path)]
+	if !ok || !e.matches(info) {
+		return cacheEntry{}, false
+	}
+	return *e, true
+}
+
+// update applies fn to the entry for path, described by info.
+// A stale entry is reset first, so fields from the old file version never
+// leak into the new one.
+func (c *metadataCache) update(path string, info os.FileInfo, fn func(e *cacheEntry)) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := libraryRelPath(path)
+	e, ok := c.entries[key]
+	if !ok || !e.matches(info) {
+		e = &cacheEntry{Path: key, Size: info.Size(), ModTime: info.ModTime(), Inode: fileInode(info)}
+		c.entries[key] = e
+	}
+	fn(e)
+	c.dirty = true
+}
+
+// transfer carries the entry for src over to dst after a file was
+// transferred, so it stays cached at its new location. The src entry is
+// dropped unless keepSource is set.
+func (c *metadataCache) transfer(src, dst string, keepSource bool) {
+	if c == nil {
+		return
+	}
+	info, err := os.Stat(dst)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	srcKey := libraryRelPath(src)
+	e, ok := c.entries[srcKey]
+	if !ok || e.Size != info.Size() || !e.ModTime.Equal(info.ModTime()) {
+		return
+	}
+
+	moved := *e
+	moved.Path = libraryRelPath(dst)
+	moved.Inode = fileInode(info)
+	c.entries[moved.Path] = &moved
+	if !keepSource {
+		delete(c.entries, srcKey)
+	}
+	c.dirty = true
+}
+
+// prune drops entries whose file is gone or has changed.
+// Returns the number of entries kept and removed.
+func (c *metadataCache) prune() (int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for key, e := range c.entries {
+		info, err := os.Stat(resolveLibraryPath(key))
+		if err != nil || !e.matches(info) {
+			delete(c.entries, key)
+			removed++
+		}
+	}
+	if removed > 0 {
+		c.dirty = true
+	}
+	return len(c.entries), removed
+}
+
+// save writes the cache back to disk if it changed.
+func (c *metadataCache) save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+
+	var keys []string
+	for k := range c.entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	err := writeFileAtomic(c.path, 0644, func(w io.Writer) error {
+		writer := csv.NewWriter(w)
+		writer.Write(cacheHeaders)
+		for _, k := range keys {
+			e := c.entries[k]
+			captureDate := ""
+			if !e.CaptureDate.IsZero() {
+				captureDate = e.CaptureDate.Format(time.RFC3339Nano)
+			}
+			camera := e.Camera.values()
+			row := append([]string{
+				e.Path,
+				strconv.FormatInt(e.Size, 10),
+				e.ModTime.Format(time.RFC3339Nano),
+				strconv.FormatUint(e.Inode, 10),
+				captureDate,
+				e.DateSource,
+				camera[0],
+				camera[1],
+				e.Hash,
+				e.SHA256,
+			}, camera[2:]...)
+			row = append(row, gpsValues(e.GPS)...)
+			writer.Write(append(row, e.Video.values()...))
+		}
+		writer.Flush()
+		return writer.Error()
+	})
+	if err != nil {
+		return err
+	}
+
+	c.dirty = false
+	return nil
+}
+
+// saveMetadataCache saves metaCache, warning (not failing) on error.
+func saveMetadataCache() {
+	if err := metaCache.save(); err != nil {
+		fmt.Printf("Warning: could not save metadata cache: %v\n", err)
+	}
+}
+
+// runCache implements the cache subcommand.
+// Returns the process exit code.
+func runCache(args []string) int {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Manage the metadata cache in _Manifest/\n\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "  %s cache prune [--root /path]   # Drop entries for missing or changed files\n", os.Args[0])
+	}
+	if len(args) == 0 || args[0] != "prune" {
+		usage()
+		return exitUsage
+	}
+
+	fs := flag.NewFlagSet("cache prune", flag.ExitOnError)
+	rootDir := fs.String("root", "", "Photo library root directory (default: current directory)")
+	fs.Parse(args[1:])
+
+	if err := setLibraryPaths(*rootDir); err != nil {
+		fmt.Println("Error getting current directory:", err)
+		return exitFatal
+	}
+
+	cache := loadMetadataCache(cacheFile)
+	kept, removed := cache.prune()
+	if err := cache.save(); err != nil {
+		fmt.Println("Error saving metadata cache:", err)
+		return exitFatal
+	}
+
+	fmt.Printf("Pruned %d stale entries, %d remain\n", removed, kept)
+	return exitOK
+}
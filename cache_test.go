@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMetadataCacheRelativeKeys(t *testing.T) {
+	root := newTestLibrary(t)
+	path := writeTestFile(t, "Originals/2024/2024-06-01/IMG_0001.JPG", "data")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := loadMetadataCache(cacheFile)
+	c.update(path, info, func(e *cacheEntry) { e.Hash = "abc" })
+	if err := c.save(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(cacheFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), root) {
+		t.Errorf("cache file contains the library root:\n%s", data)
+	}
+
+	// A cache keyed by absolute path is rekeyed relative to the root
+	abs := filepath.ToSlash(path)
+	if err := os.WriteFile(cacheFile, []byte(strings.Replace(string(data),
+		"Originals/2024/2024-06-01/IMG_0001.JPG", abs, 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c = loadMetadataCache(cacheFile)
+	if _, ok := c.entries[abs]; ok {
+		t.Error("absolute cache key was kept")
+	}
+	if e, ok := c.lookup(path, info); !ok || e.Hash != "abc" {
+		t.Errorf("lookup = %+v, %v; want the rekeyed entry", e, ok)
+	}
+	if !c.dirty {
+		t.Error("rekeyed cache is not marked for saving")
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// =============================================================================
+// Camera and Exposure Metadata
+// =============================================================================
+
+// cameraInfo is the camera, lens and exposure metadata recorded in a photo's
+// EXIF. It is extracted from the same decode as the capture date. Zero
+// values mean the field is not recorded.
+type cameraInfo struct {
+	Make         string  `json:"make,omitempty"`
+	Model        string  `json:"model,omitempty"`
+	Serial       string  `json:"serial,omitempty"`
+	Lens         string  `json:"lens,omitempty"`
+	FocalLength  float64 `json:"focal_length_mm,omitempty"` // Millimetres
+	Aperture     float64 `json:"f_number,omitempty"`
+	ShutterSpeed string  `json:"shutter_speed,omitempty"` // e.g. "1/250" or "2"
+	ISO          int     `json:"iso,omitempty"`
+	Orientation  int     `json:"orientation,omitempty"` // EXIF orientation, 1-8
+	Width        int     `json:"width,omitempty"`       // Pixels
+	Height       int     `json:"height,omitempty"`      // Pixels
+}
+
+// cameraColumns are the manifest and cache columns of a cameraInfo, in the
+// order of values and parseCameraInfo.
+var cameraColumns = []string{
+	"camera_make",
+	"camera_model",
+	"camera_serial",
+	"lens_model",
+	"focal_length_mm",
+	"f_number",
+	"shutter_speed",
+	"iso",
+	"orientation",
+	"width_px",
+	"height_px",
+}
+
+// values returns c's column values, with "" for unrecorded fields.
+func (c cameraInfo) values() []string {
+	return []string{
+		c.Make,
+		c.Model,
+		c.Serial,
+		c.Lens,
+		formatOptionalFloat(c.FocalLength),
+		formatOptionalFloat(c.Aperture),
+		c.ShutterSpeed,
+		formatOptionalInt(c.ISO),
+		formatOptionalInt(c.Orientation),
+		formatOptionalInt(c.Width),
+		formatOptionalInt(c.Height),
+	}
+}
+
+// parseCameraInfo parses column values written by values. Missing or
+// invalid values are left zero.
+func parseCameraInfo(vals []string) cameraInfo {
+	get := func(i int) string {
+		if i < len(vals) {
+			return vals[i]
+		}
+		return ""
+	}
+	c := cameraInfo{
+		Make:         get(0),
+		Model:        get(1),
+		Serial:       get(2),
+		Lens:         get(3),
+		ShutterSpeed: get(6),
+	}
+	c.FocalLength, _ = strconv.ParseFloat(get(4), 64)
+	c.Aperture, _ = strconv.ParseFloat(get(5), 64)
+	c.ISO, _ = strconv.Atoi(get(7))
+	c.Orientation, _ = strconv.Atoi(get(8))
+	c.Width, _ = strconv.Atoi(get(9))
+	c.Height, _ = strconv.Atoi(get(10))
+	return c
+}
+
+// formatOptionalFloat formats v, or returns "" for zero.
+func formatOptionalFloat(v float64) string {
+	if v == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// formatOptionalInt formats v, or returns "" for zero.
+func formatOptionalInt(v int) string {
+	if v == 0 {
+		return ""
+	}
+	return strconv.Itoa(v)
+}
+
+// exifCamera returns the camera, lens and exposure metadata in x.
+// x may be nil.
+func exifCamera(x *exif.Exif) cameraInfo {
+	c := cameraInfo{
+		Make:   exifString(x, exif.Make),
+		Model:  exifString(x, exif.Model),
+		Serial: exifString(x, exifBodySerialNumber),
+		Lens:   exifString(x, exif.LensModel),
+	}
+	if x == nil {
+		return c
+	}
+
+	c.FocalLength = roundTo(exifFloat(x, exif.FocalLength), 1)
+	c.Aperture = roundTo(exifFloat(x, exif.FNumber), 1)
+	if tag, err := x.Get(exif.ExposureTime); err == nil {
+		if num, den, err := tag.Rat2(0); err == nil && num > 0 && den > 0 {
+			c.ShutterSpeed = formatShutterSpeed(num, den)
+		}
+	}
+	c.ISO = exifInt(x, exif.ISOSpeedRatings)
+	c.Orientation = exifInt(x, exif.Orientation)
+
+	c.Width, c.Height = exifInt(x, exif.PixelXDimension), exifInt(x, exif.PixelYDimension)
+	if c.Width == 0 || c.Height == 0 {
+		c.Width, c.Height = exifInt(x, exif.ImageWidth), exifInt(x, exif.ImageLength)
+	}
+	return c
+}
+
+// exifFloat returns a rational EXIF field as a float, or 0 if it is missing.
+func exifFloat(x *exif.Exif, name exif.FieldName) float64 {
+	tag, err := x.Get(name)
+	if err != nil {
+		return 0
+	}
+	num, den, err := tag.Rat2(0)
+	if err != nil || den == 0 {
+		return 0
+	}
+	return float64(num) / float64(den)
+}
+
+// exifInt returns an integer EXIF field, or 0 if it is missing.
+func exifInt(x *exif.Exif, name exif.FieldName) int {
+	tag, err := x.Get(name)
+	if err != nil {
+		return 0
+	}
+	v, err := tag.Int(0)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+// formatShutterSpeed formats an exposure time of num/den seconds the way
+// cameras show it: "1/250" below a second, "2" or "2.5" above.
+func formatShutterSpeed(num, den int64) string {
+	if num < den {
+		return fmt.Sprintf("1/%d", int64(math.Round(float64(den)/float64(num))))
+	}
+	return strconv.FormatFloat(roundTo(float64(num)/float64(den), 1), 'f', -1, 64)
+}
+
+// roundTo rounds v to the given number of decimal places.
+func roundTo(v float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(v*p) / p
+}
+
+// exifBodySerialNumber is the EXIF 2.3 camera body serial number, which
+// goexif doesn't name.
+const exifBodySerialNumber exif.FieldName = "BodySerialNumber"
+
+// extraExifFields are the EXIF sub-IFD tags loaded by extraExifParser.
+var extraExifFields = map[uint16]exif.FieldName{
+	0xA431: exifBodySerialNumber,
+}
+
+// extraExifParser loads extraExifFields when EXIF is decoded.
+type extraExifParser struct{}
+
+// Parse implements exif.Parser. Files without an EXIF sub-IFD are fine.
+func (extraExifParser) Parse(x *exif.Exif) error {
+	tag, err := x.Get(exif.ExifIFDPointer)
+	if err != nil {
+		return nil
+	}
+	offset, err := tag.Int64(0)
+	if err != nil {
+		return nil
+	}
+
+	r := bytes.NewReader(x.Raw)
+	if _, err := r.Seek(offset, 0); err != nil {
+		return nil
+	}
+	dir, _, err := tiff.DecodeDir(r, x.Tiff.Order)
+	if err != nil {
+		return nil
+	}
+	x.LoadTags(dir, extraExifFields, false)
+	return nil
+}
+
+func init() {
+	exif.RegisterParsers(extraExifParser{})
+}
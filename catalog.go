@@ -0,0 +1,358 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // Pure-Go SQLite driver, registered as "sqlite"
+)
+
+// =============================================================================
+// SQLite Catalog
+// =============================================================================
+
+// catalogSchemaVersion is stored in the catalog's user_version.
+const catalogSchemaVersion = 6
+
+// catalogSchema creates the version 1 catalog tables:
+//   - files: one row per organized file, unique by relative path
+//   - hashes: content hashes of files, indexed for duplicate lookups
+//   - runs: each run that changed the catalog
+//   - moves: where each file was organized from, per run
+var catalogSchema = []string{
+	`CREATE TABLE IF NOT EXISTS runs (
+		id          TEXT PRIMARY KEY,
+		started     TEXT NOT NULL,
+		command     TEXT NOT NULL,
+		version     TEXT NOT NULL,
+		root        TEXT NOT NULL,
+		files_added INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE TABLE IF NOT EXISTS files (
+		id             INTEGER PRIMARY KEY,
+		relative_path  TEXT NOT NULL UNIQUE,
+		filename       TEXT NOT NULL,
+		source_folder  TEXT NOT NULL,
+		size           INTEGER NOT NULL,
+		modified       TEXT NOT NULL,
+		capture_date   TEXT NOT NULL,
+		camera_make    TEXT NOT NULL,
+		camera_model   TEXT NOT NULL,
+		extension      TEXT NOT NULL,
+		organized_date TEXT NOT NULL,
+		run_id         TEXT REFERENCES runs(id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS files_capture_date ON files(capture_date)`,
+	`CREATE INDEX IF NOT EXISTS files_size ON files(size)`,
+	`CREATE TABLE IF NOT EXISTS hashes (
+		file_id   INTEGER NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+		algorithm TEXT NOT NULL,
+		value     TEXT NOT NULL,
+		PRIMARY KEY (file_id, algorithm)
+	)`,
+	`CREATE INDEX IF NOT EXISTS hashes_value ON hashes(algorithm, value)`,
+	`CREATE TABLE IF NOT EXISTS moves (
+		id          INTEGER PRIMARY KEY,
+		run_id      TEXT NOT NULL REFERENCES runs(id),
+		file_id     INTEGER NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+		source_path TEXT NOT NULL,
+		dest_path   TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS moves_source ON moves(source_path)`,
+	`CREATE INDEX IF NOT EXISTS moves_file ON moves(file_id)`,
+}
+
+// catalogMigrations upgrade the catalog schema; catalogMigrations[v] takes
+// a catalog from version v+1 to v+2. Append to add columns, matching new
+// manifest CSV columns.
+var catalogMigrations = [][]string{
+	{`ALTER TABLE files ADD COLUMN date_source TEXT NOT NULL DEFAULT ''`},
+	{
+		`ALTER TABLE files ADD COLUMN camera_serial TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE files ADD COLUMN lens_model TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE files ADD COLUMN focal_length_mm REAL NOT NULL DEFAULT 0`,
+		`ALTER TABLE files ADD COLUMN f_number REAL NOT NULL DEFAULT 0`,
+		`ALTER TABLE files ADD COLUMN shutter_speed TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE files ADD COLUMN iso INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE files ADD COLUMN orientation INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE files ADD COLUMN width_px INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE files ADD COLUMN height_px INTEGER NOT NULL DEFAULT 0`,
+	},
+	{
+		`ALTER TABLE files ADD COLUMN gps_latitude REAL`,
+		`ALTER TABLE files ADD COLUMN gps_longitude REAL`,
+		`ALTER TABLE files ADD COLUMN gps_altitude_m REAL`,
+	},
+	{
+		`ALTER TABLE files ADD COLUMN place_city TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE files ADD COLUMN place_region TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE files ADD COLUMN place_country TEXT NOT NULL DEFAULT ''`,
+	},
+	{
+		`ALTER TABLE files ADD COLUMN duration_s REAL NOT NULL DEFAULT 0`,
+		`ALTER TABLE files ADD COLUMN frame_rate REAL NOT NULL DEFAULT 0`,
+		`ALTER TABLE files ADD COLUMN video_codec TEXT NOT NULL DEFAULT ''`,
+	},
+}
+
+// catalogFileColumns are the files columns written by add and update, in
+// the order of catalogFileValues.
+var catalogFileColumns = []string{
+	"relative_path", "filename", "source_folder", "size", "modified",
+	"capture_date", "date_source", "camera_make", "camera_model",
+	"camera_serial", "lens_model", "focal_length_mm", "f_number", "shutter_speed",
+	"iso", "orientation", "width_px", "height_px",
+	"gps_latitude", "gps_longitude", "gps_altitude_m",
+	"place_city", "place_region", "place_country",
+	"duration_s", "frame_rate", "video_codec",
+	"extension", "organized_date", "run_id",
+}
+
+// catalogFileValues returns r's values for catalogFileColumns.
+func catalogFileValues(r manifestRecord) []any {
+	c := r.Camera
+	var lat, lon, alt sql.NullFloat64
+	if r.GPS != nil {
+		lat = sql.NullFloat64{Float64: r.GPS.Lat, Valid: true}
+		lon = sql.NullFloat64{Float64: r.GPS.Lon, Valid: true}
+		if r.GPS.Alt != nil {
+			alt = sql.NullFloat64{Float64: *r.GPS.Alt, Valid: true}
+		}
+	}
+	return []any{
+		r.RelativePath, r.Filename, r.SourceFolder, r.Size, r.Modified.Format(manifestTimeLayout),
+		r.CaptureDate.Format(manifestTimeLayout), r.DateSource, c.Make, c.Model,
+		c.Serial, c.Lens, c.FocalLength, c.Aperture, c.ShutterSpeed,
+		c.ISO, c.Orientation, c.Width, c.Height,
+		lat, lon, alt,
+		r.Place.City, r.Place.Region, r.Place.Country,
+		r.Video.Duration, r.Video.FrameRate, r.Video.Codec,
+		r.Extension, r.OrganizedDate.Format(manifestTimeLayout), runID,
+	}
+}
+
+// catalogInsertFile returns the INSERT statement for a files row, ending
+// with the given conflict clause.
+func catalogInsertFile(onConflict string) string {
+	return fmt.Sprintf("INSERT INTO files (%s) VALUES (%s) %s",
+		strings.Join(catalogFileColumns, ", "),
+		strings.TrimSuffix(strings.Repeat("?, ", len(catalogFileColumns)), ", "),
+		onConflict)
+}
+
+// hashPartialMD5 is the hashes.algorithm of the manifest's file_hash.
+const hashPartialMD5 = "md5-64k"
+
+// catalogManifest is the manifest stored in a SQLite catalog. Updates only
+// touch the rows they add, in one transaction.
+type catalogManifest struct {
+	db *sql.DB
+}
+
+// openCatalog opens (creating if needed) the SQLite catalog at path.
+func openCatalog(path string) (*catalogManifest, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1) // One writer; keeps pragmas on the same connection
+
+	stmts := []string{
+		"PRAGMA foreign_keys = ON",
+		// Rollback journal: WAL relies on shared memory, which is unsafe
+		// when the library is on a network share
+		"PRAGMA journal_mode = DELETE",
+		"PRAGMA busy_timeout = 5000",
+	}
+	var schema int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot read catalog %s: %v", path, err)
+	}
+	if schema > catalogSchemaVersion {
+		db.Close()
+		return nil, fmt.Errorf("catalog %s has schema version %d, newer than this tool supports (%d)", path, schema, catalogSchemaVersion)
+	}
+	if schema == 0 {
+		stmts = append(stmts, catalogSchema...)
+		schema = 1
+	}
+	for v := schema; v < catalogSchemaVersion; v++ {
+		stmts = append(stmts, catalogMigrations[v-1]...)
+	}
+	stmts = append(stmts, fmt.Sprintf("PRAGMA user_version = %d", catalogSchemaVersion))
+
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("cannot initialize catalog %s: %v", path, err)
+		}
+	}
+	return &catalogManifest{db: db}, nil
+}
+
+// records implements manifestBackend.
+func (c *catalogManifest) records() ([]manifestRecord, error) {
+	return c.recordsWhere("1", nil)
+}
+
+// recordsWhere returns the records of the files rows matching the SQL
+// condition where, with args for its placeholders, so filters can use the
+// catalog's indexes. Columns are those of files, aliased f.
+func (c *catalogManifest) recordsWhere(where string, args []any) ([]manifestRecord, error) {
+	rows, err := c.db.Query(`
+		SELECT f.filename, f.relative_path, f.source_folder, f.size, f.modified,
+		       f.capture_date, f.date_source, f.camera_make, f.camera_model,
+		       f.camera_serial, f.lens_model, f.focal_length_mm, f.f_number, f.shutter_speed,
+		       f.iso, f.orientation, f.width_px, f.height_px,
+		       f.gps_latitude, f.gps_longitude, f.gps_altitude_m,
+		       f.place_city, f.place_region, f.place_country,
+		       f.duration_s, f.frame_rate, f.video_codec, COALESCE(h.value, ''),
+		       f.extension, f.organized_date,
+		       COALESCE((SELECT m.source_path FROM moves m WHERE m.file_id = f.id ORDER BY m.id DESC LIMIT 1), '')
+		FROM files f
+		LEFT JOIN hashes h ON h.file_id = f.id AND h.algorithm = ?
+		WHERE `+where+`
+		ORDER BY f.relative_path`, append([]any{hashPartialMD5}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recs []manifestRecord
+	for rows.Next() {
+		var r manifestRecord
+		var modified, captureDate, organized string
+		var lat, lon, alt sql.NullFloat64
+		c := &r.Camera
+		err := rows.Scan(&r.Filename, &r.RelativePath, &r.SourceFolder, &r.Size, &modified,
+			&captureDate, &r.DateSource, &c.Make, &c.Model,
+			&c.Serial, &c.Lens, &c.FocalLength, &c.Aperture, &c.ShutterSpeed,
+			&c.ISO, &c.Orientation, &c.Width, &c.Height,
+			&lat, &lon, &alt, &r.Place.City, &r.Place.Region, &r.Place.Country,
+			&r.Video.Duration, &r.Video.FrameRate, &r.Video.Codec, &r.Hash, &r.Extension, &organized, &r.SourcePath)
+		if err != nil {
+			return nil, err
+		}
+		r.Modified, _ = time.ParseInLocation(manifestTimeLayout, modified, time.Local)
+		r.CaptureDate, _ = time.ParseInLocation(manifestTimeLayout, captureDate, time.Local)
+		r.OrganizedDate, _ = time.ParseInLocation(manifestTimeLayout, organized, time.Local)
+		if lat.Valid && lon.Valid {
+			r.GPS = newGPSPosition(lat.Float64, lon.Float64)
+			if r.GPS != nil && alt.Valid {
+				r.GPS.Alt = &alt.Float64
+			}
+		}
+		recs = append(recs, r)
+	}
+	return recs, rows.Err()
+}
+
+// add implements manifestBackend. The run, each new file, its hash and
+// where it was organized from are recorded in one transaction.
+func (c *catalogManifest) add(recs []manifestRecord) (int, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := recordCatalogRun(tx); err != nil {
+		return 0, err
+	}
+
+	insertFile, err := tx.Prepare(catalogInsertFile("ON CONFLICT(relative_path) DO NOTHING"))
+	if err != nil {
+		return 0, err
+	}
+	defer insertFile.Close()
+
+	added := 0
+	for _, r := range recs {
+		res, err := insertFile.Exec(catalogFileValues(r)...)
+		if err != nil {
+			return 0, fmt.Errorf("adding %s: %v", r.RelativePath, err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue // Already in the catalog
+		}
+		fileID, err := res.LastInsertId()
+		if err != nil {
+			return 0, err
+		}
+
+		if r.Hash != "" {
+			if _, err := tx.Exec(`INSERT INTO hashes (file_id, algorithm, value) VALUES (?, ?, ?)`,
+				fileID, hashPartialMD5, r.Hash); err != nil {
+				return 0, err
+			}
+		}
+		if r.SourcePath != "" {
+			if _, err := tx.Exec(`INSERT INTO moves (run_id, file_id, source_path, dest_path) VALUES (?, ?, ?, ?)`,
+				runID, fileID, r.SourcePath, r.RelativePath); err != nil {
+				return 0, err
+			}
+		}
+		added++
+	}
+
+	if _, err := tx.Exec(`UPDATE runs SET files_added = files_added + ? WHERE id = ?`, added, runID); err != nil {
+		return 0, err
+	}
+	return added, tx.Commit()
+}
+
+// update implements manifestBackend.
+func (c *catalogManifest) update(recs []manifestRecord) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := recordCatalogRun(tx); err != nil {
+		return err
+	}
+
+	// Every column but the key and the run that first added the file
+	var set []string
+	for _, col := range catalogFileColumns {
+		if col != "relative_path" && col != "run_id" {
+			set = append(set, col+" = excluded."+col)
+		}
+	}
+	upsert := catalogInsertFile("ON CONFLICT(relative_path) DO UPDATE SET " + strings.Join(set, ", "))
+
+	for _, r := range recs {
+		_, err := tx.Exec(upsert, catalogFileValues(r)...)
+		if err != nil {
+			return fmt.Errorf("updating %s: %v", r.RelativePath, err)
+		}
+
+		_, err = tx.Exec(`INSERT INTO hashes (file_id, algorithm, value)
+			SELECT id, ?, ? FROM files WHERE relative_path = ? AND ? != ''
+			ON CONFLICT(file_id, algorithm) DO UPDATE SET value = excluded.value`,
+			hashPartialMD5, r.Hash, r.RelativePath, r.Hash)
+		if err != nil {
+			return fmt.Errorf("updating hash of %s: %v", r.RelativePath, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// recordCatalogRun records the current run in the runs table, once.
+func recordCatalogRun(tx *sql.Tx) error {
+	_, err := tx.Exec(`INSERT INTO runs (id, started, command, version, root) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO NOTHING`,
+		runID, time.Now().Format(manifestTimeLayout), strings.Join(os.Args, " "), version, photoRoot)
+	return err
+}
+
+// close implements manifestBackend.
+func (c *catalogManifest) close() error {
+	return c.db.Close()
+}
@@ -0,0 +1,26 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// errNoStatfs is returned where filesystem queries are not implemented.
+var errNoStatfs = errors.New("filesystem queries not supported on this platform")
+
+// deviceID is not supported on this platform.
+func deviceID(path string) (string, error) {
+	return "", errNoStatfs
+}
+
+// freeSpace is not supported on this platform.
+func freeSpace(path string) (uint64, error) {
+	return 0, errNoStatfs
+}
+
+// fileInode is not supported on this platform.
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// =============================================================================
+// Library Check (fsck)
+// =============================================================================
+
+// fsckCheck is the result of checking one manifest entry against the file
+// it records.
+type fsckCheck struct {
+	missing bool
+	changed string         // Why the file no longer matches its entry, if it doesn't
+	fixed   manifestRecord // The entry updated to the file on disk, if changed
+	fixable bool           // fixed can replace the entry
+}
+
+// checkManifestEntry compares r with its file in the library. The hash is
+// always computed fresh, never taken from the metadata cache.
+func checkManifestEntry(r manifestRecord) fsckCheck {
+	path := filepath.Join(photoRoot, r.RelativePath)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return fsckCheck{missing: true}
+	}
+	if err != nil {
+		return fsckCheck{changed: fmt.Sprintf("cannot read: %v", err)}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fsckCheck{changed: fmt.Sprintf("cannot read: %v", err)}
+	}
+	hash := partialHash(f)
+	f.Close()
+
+	var reasons []string
+	if info.Size() != r.Size {
+		reasons = append(reasons, fmt.Sprintf("size %d -> %d", r.Size, info.Size()))
+	}
+	if r.Hash != "" && hash != r.Hash {
+		reasons = append(reasons, "content changed")
+	}
+	if len(reasons) == 0 {
+		return fsckCheck{}
+	}
+
+	r.Size, r.Hash, r.Modified = info.Size(), hash, info.ModTime()
+	return fsckCheck{changed: strings.Join(reasons, ", "), fixed: r, fixable: true}
+}
+
+// layoutLabelWildcard stands in for the event label when expanding the
+// folder layout for a manifest entry, which doesn't record the label.
+const layoutLabelWildcard = "\ue000"
+
+// layoutFolder returns the folder under Originals/ that the folder layout
+// gives r, with its place taken from the manifest.
+func layoutFolder(r manifestRecord) string {
+	return expandLayoutPlace(fileMeta{CaptureDate: r.CaptureDate, GPS: r.GPS}, r.Place)
+}
+
+// inLayoutFolder reports whether r's file is in the folder the folder layout
+// gives it. Any event label, or none, matches {label}. Entries without a
+// capture date, or outside Originals/, always match.
+func inLayoutFolder(r manifestRecord) bool {
+	if r.CaptureDate.IsZero() {
+		return true
+	}
+	rel, err := filepath.Rel(originalsDir, filepath.Join(photoRoot, r.RelativePath))
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return true
+	}
+
+	actual := strings.Split(filepath.ToSlash(filepath.Dir(rel)), "/")
+	plain := strings.Split(filepath.ToSlash(layoutFolder(r)), "/")
+	labeled := strings.Split(filepath.ToSlash(expandLayoutPlace(
+		fileMeta{CaptureDate: r.CaptureDate, GPS: r.GPS, EventLabel: layoutLabelWildcard}, r.Place)), "/")
+	if len(actual) != len(plain) || len(actual) != len(labeled) {
+		return false
+	}
+
+	for i, folder := range actual {
+		if folder == plain[i] {
+			continue
+		}
+		prefix, suffix, ok := strings.Cut(labeled[i], layoutLabelWildcard)
+		if !ok || len(folder) <= len(prefix)+len(suffix) ||
+			!strings.HasPrefix(folder, prefix) || !strings.HasSuffix(folder, suffix) {
+			return false
+		}
+	}
+	return true
+}
+
+// runFsck implements the fsck subcommand: check that Originals/ still
+// matches the manifest. Reports entries whose file is missing, files whose
+// size or content changed, files outside the folder the saved layout gives
+// them, and media files in Originals/ the manifest doesn't know. With --fix,
+// changed entries are updated and untracked files adopted; missing and
+// misplaced files are only reported. Returns exitPartial if any problem
+// remains.
+func runFsck(args []string) int {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	rootDir := fs.String("root", "", "Photo library root directory (default: current directory)")
+	fix := fs.Bool("fix", false, "Update changed entries and adopt untracked files into the manifest")
+	fs.IntVar(&jobs, "jobs", jobs, "Number of files to check in parallel")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Check the library against the manifest\n\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "  %s fsck [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	if err := setLibraryPaths(*rootDir); err != nil {
+		fmt.Println("Error getting current directory:", err)
+		return exitFatal
+	}
+	if err := loadFolderLayout(""); err != nil {
+		fmt.Println("Error:", err)
+		return exitFatal
+	}
+
+	if *fix {
+		lock, err := acquireLibraryLock(0)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return exitFatal
+		}
+		defer lock.release()
+	}
+
+	manifest, err := openManifest()
+	if err != nil {
+		fmt.Println("Error opening manifest:", err)
+		return exitFatal
+	}
+	defer manifest.close()
+
+	recs, err := manifest.records()
+	if err != nil {
+		fmt.Println("Error reading manifest:", err)
+		return exitFatal
+	}
+
+	fmt.Printf("Checking %d manifest entries against %s\n\n", len(recs), originalsDir)
+
+	tracked := make(map[string]bool)
+	var missing, changed, misplaced []string
+	var updates []manifestRecord
+	parallelOrdered(len(recs), func(i int) fsckCheck {
+		return checkManifestEntry(recs[i])
+	}, func(i int, c fsckCheck) {
+		r := recs[i]
+		tracked[filepath.Join(photoRoot, r.RelativePath)] = true
+		switch {
+		case c.missing:
+			missing = append(missing, r.RelativePath)
+			return
+		case c.changed != "":
+			changed = append(changed, fmt.Sprintf("%s (%s)", r.RelativePath, c.changed))
+			if c.fixable {
+				updates = append(updates, c.fixed)
+			}
+		}
+		if !inLayoutFolder(r) {
+			misplaced = append(misplaced, fmt.Sprintf("%s (expected in %s)", r.RelativePath, filepath.ToSlash(layoutFolder(r))))
+		}
+	})
+
+	files, err := findMediaFiles(originalsDir)
+	if err != nil {
+		fmt.Println("Error scanning library:", err)
+		return exitFatal
+	}
+	var untracked []string
+	for _, path := range files {
+		if !tracked[path] {
+			untracked = append(untracked, path)
+		}
+	}
+
+	printFsckSection("Missing (in the manifest, not on disk)", "✗", missing)
+	printFsckSection("Changed (size or content differs from the manifest)", "~", changed)
+	printFsckSection("Not in the folder the layout gives them", "!", misplaced)
+	var untrackedRel []string
+	for _, path := range untracked {
+		untrackedRel = append(untrackedRel, displayPath(path))
+	}
+	printFsckSection("Untracked (in Originals, not in the manifest)", "+", untrackedRel)
+
+	fmt.Printf("Missing:   %d\n", len(missing))
+	fmt.Printf("Changed:   %d\n", len(changed))
+	fmt.Printf("Misplaced: %d\n", len(misplaced))
+	fmt.Printf("Untracked: %d\n", len(untracked))
+
+	remaining := len(missing) + len(changed) + len(misplaced) + len(untracked)
+	if *fix && len(updates)+len(untracked) > 0 {
+		fmt.Println()
+		if len(updates) > 0 {
+			if err := manifest.update(updates); err != nil {
+				fmt.Println("Error updating manifest:", err)
+				return exitFatal
+			}
+			fmt.Printf("Updated %d changed entries\n", len(updates))
+			remaining -= len(updates)
+		}
+
+		if len(untracked) > 0 {
+			metas := extractMetadata(originalsDir, untracked, nil)
+			var adopted []manifestRecord
+			for _, m := range metas {
+				if m.Err != nil {
+					fmt.Printf("  ✗ %s (cannot read: %v)\n", displayPath(m.Path), m.Err)
+					continue
+				}
+				r := adoptedRecord(m)
+				if !inLayoutFolder(r) {
+					fmt.Printf("  ! %s (adopted, but expected in %s)\n", r.RelativePath, filepath.ToSlash(layoutFolder(r)))
+					remaining++
+				}
+				adopted = append(adopted, r)
+			}
+			added, err := manifest.add(adopted)
+			if err != nil {
+				fmt.Println("Error updating manifest:", err)
+				return exitFatal
+			}
+			fmt.Printf("Adopted %d untracked files\n", added)
+			remaining -= added
+		}
+	}
+
+	fmt.Println()
+	if remaining > 0 {
+		if *fix {
+			fmt.Printf("FAIL: %d problems remain (missing and misplaced files are only reported)\n", remaining)
+		} else {
+			fmt.Printf("FAIL: %d problems found (run with --fix to update changed entries and adopt untracked files)\n", remaining)
+		}
+		return exitPartial
+	}
+	fmt.Println("OK: the library matches the manifest")
+	return exitOK
+}
+
+// printFsckSection prints a titled list of problems, if there are any.
+func printFsckSection(title, mark string, items []string) {
+	if len(items) == 0 {
+		return
+	}
+	fmt.Printf("%s:\n", title)
+	for _, item := range items {
+		fmt.Printf("  %s %s\n", mark, item)
+	}
+	fmt.Println()
+}
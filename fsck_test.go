@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInLayoutFolder(t *testing.T) {
+	captured := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+	lisbon := placeName{City: "Lisbon", Region: "Lisbon", Country: "Portugal"}
+
+	tests := []struct {
+		layout string
+		path   string
+		place  placeName
+		want   bool
+	}{
+		{defaultFolderLayout, "Originals/2024/2024-06-01/a.jpg", placeName{}, true},
+		{defaultFolderLayout, "Originals/2024/2024-06-01 Beach Day/a.jpg", placeName{}, true},
+		{defaultFolderLayout, "Originals/2024/2024-06-02/a.jpg", placeName{}, false},
+		{defaultFolderLayout, "Originals/2023/2024-06-01/a.jpg", placeName{}, false},
+		{defaultFolderLayout, "Originals/2024/2024-06-01x/a.jpg", placeName{}, false},
+		{"{year}/{month}/{day}", "Originals/2024/06/01/a.jpg", placeName{}, true},
+		{"{year}/{month}/{day}", "Originals/2024/2024-06-01/a.jpg", placeName{}, false},
+		{"{year}/{date} {city}", "Originals/2024/2024-06-01 Lisbon/a.jpg", lisbon, true},
+		{"{year}/{date} {city}", "Originals/2024/2024-06-01/a.jpg", placeName{}, true},
+		{"{year}/{date} {city}", "Originals/2024/2024-06-01/a.jpg", lisbon, false},
+		{"{country}/{label} {year}", "Originals/Portugal/Surf Trip 2024/a.jpg", lisbon, true},
+		{"{country}/{label} {year}", "Originals/Portugal/2024/a.jpg", lisbon, true},
+		{"{country}/{label} {year}", "Originals/Spain/2024/a.jpg", lisbon, false},
+		{defaultFolderLayout, "Elsewhere/a.jpg", placeName{}, true},
+	}
+
+	newTestLibrary(t)
+	defer func() { folderLayout = defaultFolderLayout }()
+	for _, tt := range tests {
+		folderLayout = tt.layout
+		r := manifestRecord{RelativePath: tt.path, CaptureDate: captured, Place: tt.place}
+		if got := inLayoutFolder(r); got != tt.want {
+			t.Errorf("layout %q: inLayoutFolder(%s) = %v, want %v", tt.layout, tt.path, got, tt.want)
+		}
+	}
+
+	// Entries without a capture date can't be checked
+	folderLayout = defaultFolderLayout
+	if !inLayoutFolder(manifestRecord{RelativePath: "Originals/Scans/a.jpg"}) {
+		t.Error("an undated entry was reported as misplaced")
+	}
+}
+
+func TestLoadFolderLayout(t *testing.T) {
+	newTestLibrary(t)
+	defer func() { folderLayout = defaultFolderLayout }()
+
+	if err := loadFolderLayout(""); err != nil || folderLayout != defaultFolderLayout {
+		t.Fatalf("fresh library: layout %q, err %v; want the default", folderLayout, err)
+	}
+
+	// A layout given on the command line is saved for later runs
+	if err := loadFolderLayout("{year}/{month}"); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveFolderLayout(); err != nil {
+		t.Fatal(err)
+	}
+	folderLayout = defaultFolderLayout
+	if err := loadFolderLayout(""); err != nil || folderLayout != "{year}/{month}" {
+		t.Fatalf("saved layout: got %q, err %v; want {year}/{month}", folderLayout, err)
+	}
+
+	if err := loadFolderLayout("{year}/{bogus}"); err == nil {
+		t.Error("an unknown token was accepted")
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// =============================================================================
+// GPS Positions
+// =============================================================================
+
+// gpsPosition is where a photo was taken, from its EXIF GPS tags. Files
+// without a position have a nil *gpsPosition.
+type gpsPosition struct {
+	Lat float64  `json:"lat"`           // Decimal degrees, WGS 84, north positive
+	Lon float64  `json:"lon"`           // Decimal degrees, WGS 84, east positive
+	Alt *float64 `json:"alt,omitempty"` // Metres above sea level, if recorded
+}
+
+// gpsColumns are the manifest and cache columns of a position, in the order
+// of gpsValues and parseGPSPosition.
+var gpsColumns = []string{"gps_latitude", "gps_longitude", "gps_altitude_m"}
+
+// gpsValues returns p's column values; all "" if p is nil.
+func gpsValues(p *gpsPosition) []string {
+	if p == nil {
+		return []string{"", "", ""}
+	}
+	alt := ""
+	if p.Alt != nil {
+		alt = strconv.FormatFloat(*p.Alt, 'f', 1, 64)
+	}
+	return []string{
+		strconv.FormatFloat(p.Lat, 'f', 6, 64),
+		strconv.FormatFloat(p.Lon, 'f', 6, 64),
+		alt,
+	}
+}
+
+// parseGPSPosition parses column values written by gpsValues. Returns nil
+// if there is no valid latitude and longitude.
+func parseGPSPosition(vals []string) *gpsPosition {
+	if len(vals) < 2 {
+		return nil
+	}
+	lat, err1 := strconv.ParseFloat(vals[0], 64)
+	lon, err2 := strconv.ParseFloat(vals[1], 64)
+	if err1 != nil || err2 != nil {
+		return nil
+	}
+	p := newGPSPosition(lat, lon)
+	if p != nil && len(vals) > 2 {
+		if alt, err := strconv.ParseFloat(vals[2], 64); err == nil {
+			p.Alt = &alt
+		}
+	}
+	return p
+}
+
+// newGPSPosition returns the position at lat, lon, or nil if they are out
+// of range.
+func newGPSPosition(lat, lon float64) *gpsPosition {
+	if math.IsNaN(lat) || math.IsNaN(lon) || math.Abs(lat) > 90 || math.Abs(lon) > 180 {
+		return nil
+	}
+	return &gpsPosition{Lat: lat, Lon: lon}
+}
+
+// exifGPS returns the position recorded in x, or nil if there is none.
+// x may be nil.
+func exifGPS(x *exif.Exif) *gpsPosition {
+	if x == nil {
+		return nil
+	}
+	lat, lon, err := x.LatLong()
+	if err != nil {
+		return nil
+	}
+	p := newGPSPosition(lat, lon)
+	if p == nil || (lat == 0 && lon == 0) {
+		return nil // Cameras without a fix often write zeros
+	}
+
+	if tag, err := x.Get(exif.GPSAltitude); err == nil {
+		if num, den, err := tag.Rat2(0); err == nil && den != 0 {
+			alt := roundTo(float64(num)/float64(den), 1)
+			if exifInt(x, exif.GPSAltitudeRef) == 1 {
+				alt = -alt // Below sea level
+			}
+			p.Alt = &alt
+		}
+	}
+	return p
+}
+
+// =============================================================================
+// Geo Export
+// =============================================================================
+
+// geoJSONFeatureCollection is a GeoJSON (RFC 7946) FeatureCollection.
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+// geoJSONFeature is a GeoJSON Point feature for one photo.
+type geoJSONFeature struct {
+	Type     string `json:"type"`
+	Geometry struct {
+		Type        string    `json:"type"`
+		Coordinates []float64 `json:"coordinates"` // lon, lat[, alt]
+	} `json:"geometry"`
+	Properties struct {
+		Path        string `json:"path"`
+		Filename    string `json:"filename"`
+		CaptureDate string `json:"capture_date,omitempty"`
+		Camera      string `json:"camera,omitempty"`
+		Place       string `json:"place,omitempty"`
+	} `json:"properties"`
+}
+
+// writeGeoJSON writes recs, which must all have a position, as a GeoJSON
+// FeatureCollection of points.
+func writeGeoJSON(w io.Writer, recs []manifestRecord) error {
+	fc := geoJSONFeatureCollection{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+	for _, r := range recs {
+		var f geoJSONFeature
+		f.Type = "Feature"
+		f.Geometry.Type = "Point"
+		f.Geometry.Coordinates = []float64{r.GPS.Lon, r.GPS.Lat}
+		if r.GPS.Alt != nil {
+			f.Geometry.Coordinates = append(f.Geometry.Coordinates, *r.GPS.Alt)
+		}
+		f.Properties.Path = filepath.ToSlash(r.RelativePath)
+		f.Properties.Filename = r.Filename
+		if !r.CaptureDate.IsZero() {
+			f.Properties.CaptureDate = r.CaptureDate.Format(time.RFC3339)
+		}
+		f.Properties.Camera = strings.TrimSpace(r.Camera.Make + " " + r.Camera.Model)
+		f.Properties.Place = r.Place.String()
+		fc.Features = append(fc.Features, f)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(fc)
+}
+
+// gpxFile is a GPX 1.1 document holding waypoints.
+type gpxFile struct {
+	XMLName   xml.Name      `xml:"gpx"`
+	Version   string        `xml:"version,attr"`
+	Creator   string        `xml:"creator,attr"`
+	Namespace string        `xml:"xmlns,attr"`
+	Waypoints []gpxWaypoint `xml:"wpt"`
+}
+
+// gpxWaypoint is a GPX waypoint for one photo.
+type gpxWaypoint struct {
+	Lat  float64  `xml:"lat,attr"`
+	Lon  float64  `xml:"lon,attr"`
+	Ele  *float64 `xml:"ele,omitempty"`
+	Time string   `xml:"time,omitempty"`
+	Name string   `xml:"name"`
+	Desc string   `xml:"desc"`
+}
+
+// writeGPX writes recs, which must all have a position, as GPX waypoints.
+func writeGPX(w io.Writer, recs []manifestRecord) error {
+	g := gpxFile{Version: "1.1", Creator: "photo-organizer " + version, Namespace: "http://www.topografix.com/GPX/1/1"}
+	for _, r := range recs {
+		wpt := gpxWaypoint{
+			Lat:  r.GPS.Lat,
+			Lon:  r.GPS.Lon,
+			Ele:  r.GPS.Alt,
+			Name: r.Filename,
+			Desc: filepath.ToSlash(r.RelativePath),
+		}
+		if !r.CaptureDate.IsZero() {
+			wpt.Time = r.CaptureDate.UTC().Format(time.RFC3339)
+		}
+		g.Waypoints = append(g.Waypoints, wpt)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(g); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// runGeo implements the geo subcommand.
+// Returns the process exit code.
+func runGeo(args []string) int {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Work with photo locations\n\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "  %s geo export [--format geojson|gpx] [--from date] [--to date] [--out file]\n", os.Args[0])
+	}
+	if len(args) == 0 {
+		usage()
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "export":
+		return runGeoExport(args[1:])
+	}
+	usage()
+	return exitUsage
+}
+
+// runGeoExport writes the positions of the manifest's photos, optionally
+// for a capture date range, as GeoJSON or GPX, in capture order.
+func runGeoExport(args []string) int {
+	fs := flag.NewFlagSet("geo export", flag.ExitOnError)
+	rootDir := fs.String("root", "", "Photo library root directory (default: current directory)")
+	format := fs.String("format", "geojson", "Output format: geojson or gpx")
+	from := fs.String("from", "", "Captured on or after this date: YYYY, YYYY-MM or YYYY-MM-DD")
+	to := fs.String("to", "", "Captured on or before this date (the whole year, month or day)")
+	out := fs.String("out", "-", "File to write to (- for stdout)")
+	fs.Parse(args)
+
+	var write func(io.Writer, []manifestRecord) error
+	switch *format {
+	case "geojson":
+		write = writeGeoJSON
+	case "gpx":
+		write = writeGPX
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown format %q (expected geojson or gpx)\n", *format)
+		return exitUsage
+	}
+
+	q := &manifestQuery{located: true}
+	var err error
+	if *from != "" {
+		if q.from, _, err = parseQueryDate(*from); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return exitUsage
+		}
+	}
+	if *to != "" {
+		if _, q.to, err = parseQueryDate(*to); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			return exitUsage
+		}
+	}
+
+	if err := setLibraryPaths(*rootDir); err != nil {
+		fmt.Fprintln(os.Stderr, "Error getting current directory:", err)
+		return exitFatal
+	}
+
+	m, err := openManifest()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error opening manifest:", err)
+		return exitFatal
+	}
+	located, err := queryRecords(m, q)
+	m.close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading manifest:", err)
+		return exitFatal
+	}
+	sort.SliceStable(located, func(i, j int) bool {
+		return located[i].CaptureDate.Before(located[j].CaptureDate)
+	})
+
+	if *out == "-" {
+		err = write(os.Stdout, located)
+	} else {
+		err = writeFileAtomic(*out, 0644, func(w io.Writer) error {
+			return write(w, located)
+		})
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing export:", err)
+		return exitFatal
+	}
+	if *out != "-" {
+		fmt.Printf("Exported %d located photos to %s\n", len(located), *out)
+	}
+	return exitOK
+}
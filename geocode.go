@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// =============================================================================
+// Offline Reverse Geocoding
+// =============================================================================
+//
+// GPS positions are turned into place names with a GeoNames cities dump
+// (https://download.geonames.org/export/dump/) placed in _Manifest/geonames/:
+// one of cities500.txt, cities1000.txt, cities5000.txt or cities15000.txt,
+// and optionally admin1CodesASCII.txt for region names and countryInfo.txt
+// for country names (a built-in table is used otherwise). Nothing is ever
+// fetched from the network.
+
+// geonamesCityFiles are the GeoNames city dumps looked for, most detailed
+// first.
+var geonamesCityFiles = []string{"cities500.txt", "cities1000.txt", "cities5000.txt", "cities15000.txt"}
+
+// geocodeMaxKm is how far a photo may be from the nearest city for it to
+// be named after it.
+const geocodeMaxKm = 50
+
+// placeName is where a photo was taken, by name. Zero if unknown.
+type placeName struct {
+	City    string `json:"city,omitempty"`
+	Region  string `json:"region,omitempty"`  // First-level division, e.g. state
+	Country string `json:"country,omitempty"` // Country name
+}
+
+// placeColumns are the manifest columns of a placeName, in the order of
+// values and parsePlaceName.
+var placeColumns = []string{"place_city", "place_region", "place_country"}
+
+// values returns p's column values.
+func (p placeName) values() []string {
+	return []string{p.City, p.Region, p.Country}
+}
+
+// parsePlaceName parses column values written by values.
+func parsePlaceName(vals []string) placeName {
+	var p placeName
+	if len(vals) == len(placeColumns) {
+		p.City, p.Region, p.Country = vals[0], vals[1], vals[2]
+	}
+	return p
+}
+
+// String returns the place as "City, Region, Country", skipping unknown
+// parts and a region named like the city.
+func (p placeName) String() string {
+	var parts []string
+	for _, s := range []string{p.City, p.Region, p.Country} {
+		if s != "" && (len(parts) == 0 || parts[len(parts)-1] != s) {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// geoCity is one populated place from the GeoNames dump.
+type geoCity struct {
+	name    string
+	lat     float64
+	lon     float64
+	country string // ISO 3166 code
+	admin1  string // GeoNames first-level division code
+}
+
+// placeIndex finds the nearest city to a position, bucketing cities by
+// whole degrees of latitude and longitude.
+type placeIndex struct {
+	cells     map[[2]int][]geoCity
+	regions   map[string]string // "PT.14" -> "Lisbon"
+	countries map[string]string // "PT" -> "Portugal"
+}
+
+var (
+	geocoderOnce sync.Once
+	geocoder     *placeIndex // nil if no GeoNames dump is installed
+)
+
+// loadGeocoder loads the GeoNames dump from _Manifest/geonames/ the first
+// time it is needed. Returns nil if there is none.
+func loadGeocoder() *placeIndex {
+	geocoderOnce.Do(func() {
+		for _, name := range geonamesCityFiles {
+			path := filepath.Join(geonamesDir, name)
+			if _, err := os.Stat(path); err != nil {
+				continue
+			}
+			idx, err := loadPlaceIndex(path)
+			if err != nil {
+				logf(levelWarn, "Warning: could not load %s: %v\n", displayPath(path), err)
+				return
+			}
+			geocoder = idx
+			return
+		}
+	})
+	return geocoder
+}
+
+// loadPlaceIndex reads a GeoNames cities dump, and the region and country
+// names next to it if present.
+func loadPlaceIndex(path string) (*placeIndex, error) {
+	idx := &placeIndex{
+		cells:     make(map[[2]int][]geoCity),
+		regions:   make(map[string]string),
+		countries: countryNames,
+	}
+
+	cities := 0
+	err := readGeonamesFile(path, func(f []string) {
+		// geonameid, name, asciiname, alternatenames, latitude, longitude,
+		// feature class, feature code, country code, cc2, admin1 code, ...
+		if len(f) < 11 {
+			return
+		}
+		lat, err1 := strconv.ParseFloat(f[4], 64)
+		lon, err2 := strconv.ParseFloat(f[5], 64)
+		if err1 != nil || err2 != nil {
+			return
+		}
+		c := geoCity{name: f[1], lat: lat, lon: lon, country: f[8], admin1: f[10]}
+		cell := geoCell(lat, lon)
+		idx.cells[cell] = append(idx.cells[cell], c)
+		cities++
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(path)
+	readGeonamesFile(filepath.Join(dir, "admin1CodesASCII.txt"), func(f []string) {
+		if len(f) >= 2 {
+			idx.regions[f[0]] = f[1]
+		}
+	})
+	countries := make(map[string]string)
+	readGeonamesFile(filepath.Join(dir, "countryInfo.txt"), func(f []string) {
+		if len(f) >= 5 && !strings.HasPrefix(f[0], "#") {
+			countries[f[0]] = f[4]
+		}
+	})
+	if len(countries) > 0 {
+		idx.countries = countries
+	}
+
+	logf(levelDebug, "Loaded %d places from %s\n", cities, displayPath(path))
+	return idx, nil
+}
+
+// readGeonamesFile calls fn with the tab-separated fields of each line of a
+// GeoNames file.
+func readGeonamesFile(path string, fn func(fields []string)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024) // alternatenames can be long
+	for scanner.Scan() {
+		fn(strings.Split(scanner.Text(), "\t"))
+	}
+	return scanner.Err()
+}
+
+// geoCell returns the whole-degree cell holding lat, lon.
+func geoCell(lat, lon float64) [2]int {
+	return [2]int{int(math.Floor(lat)), int(math.Floor(lon))}
+}
+
+// nearest returns the name of the nearest city within geocodeMaxKm of p.
+func (idx *placeIndex) nearest(p *gpsPosition) (placeName, bool) {
+	// Cells to search around p: a degree of latitude is ~111km, one of
+	// longitude shrinks towards the poles
+	dLat := int(math.Ceil(geocodeMaxKm / 111.0))
+	dLon := 180
+	if cos := math.Cos(p.Lat * math.Pi / 180); cos > 0.01 {
+		dLon = min(180, int(math.Ceil(geocodeMaxKm/(111.0*cos))))
+	}
+
+	center := geoCell(p.Lat, p.Lon)
+	var best *geoCity
+	bestKm := float64(geocodeMaxKm)
+	for i := -dLat; i <= dLat; i++ {
+		for j := -dLon; j <= dLon; j++ {
+			lon := (center[1]+j+180+360)%360 - 180 // Wrap around the antimeridian
+			cities := idx.cells[[2]int{center[0] + i, lon}]
+			for k := range cities {
+				if km := haversineKm(p.Lat, p.Lon, cities[k].lat, cities[k].lon); km <= bestKm {
+					best, bestKm = &cities[k], km
+				}
+			}
+		}
+	}
+	if best == nil {
+		return placeName{}, false
+	}
+
+	country := idx.countries[best.country]
+	if country == "" {
+		country = best.country
+	}
+	return placeName{
+		City:    best.name,
+		Region:  idx.regions[best.country+"."+best.admin1],
+		Country: country,
+	}, true
+}
+
+// haversineKm returns the great-circle distance between two positions in
+// kilometres.
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// reverseGeocode names the place at p. Returns the zero placeName if p is
+// nil, no GeoNames dump is installed, or no city is near enough.
+func reverseGeocode(p *gpsPosition) placeName {
+	if p == nil {
+		return placeName{}
+	}
+	idx := loadGeocoder()
+	if idx == nil {
+		return placeName{}
+	}
+	place, _ := idx.nearest(p)
+	return place
+}
+
+// =============================================================================
+// Folder Layout
+// =============================================================================
+
+// defaultFolderLayout is the folder layout under Originals/, as a template
+// of layoutTokens.
+const defaultFolderLayout = "{year}/{date} {label}"
+
+// folderLayout is the layout used by getDestination, set by --layout or
+// loaded from the library by loadFolderLayout.
+var folderLayout = defaultFolderLayout
+
+// layoutFlagUsage is the help text of the --layout flag.
+const layoutFlagUsage = "Folder layout under Originals/, saved for later runs (default: the saved layout, or " +
+	defaultFolderLayout + "); tokens: {year} {month} {day} {date} {label} {city} {region} {country}"
+
+// layoutTokens are the tokens a folder layout can use, and their values for
+// a file.
+var layoutTokens = map[string]func(m fileMeta, place placeName) string{
+	"year":    func(m fileMeta, _ placeName) string { return m.CaptureDate.Format("2006") },
+	"month":   func(m fileMeta, _ placeName) string { return m.CaptureDate.Format("01") },
+	"day":     func(m fileMeta, _ placeName) string { return m.CaptureDate.Format("02") },
+	"date":    func(m fileMeta, _ placeName) string { return m.CaptureDate.Format("2006-01-02") },
+	"label":   func(m fileMeta, _ placeName) string { return m.EventLabel },
+	"city":    func(_ fileMeta, p placeName) string { return p.City },
+	"region":  func(_ fileMeta, p placeName) string { return p.Region },
+	"country": func(_ fileMeta, p placeName) string { return p.Country },
+}
+
+// placeTokens are the layout tokens that need reverse geocoding.
+var placeTokens = []string{"city", "region", "country"}
+
+// parseLayout checks a --layout template: every {token} must be known and
+// the result must stay inside Originals/.
+func parseLayout(layout string) error {
+	rest := layout
+	for {
+		start := strings.Index(rest, "{")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(rest[start:], "}")
+		if end < 0 {
+			return fmt.Errorf("unclosed { in layout %q", layout)
+		}
+		token := rest[start+1 : start+end]
+		if layoutTokens[token] == nil {
+			return fmt.Errorf("unknown layout token {%s} (known: year, month, day, date, label, city, region, country)", token)
+		}
+		rest = rest[start+end+1:]
+	}
+	for _, segment := range strings.Split(layout, "/") {
+		if strings.TrimSpace(segment) == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("invalid layout %q: every folder must be named", layout)
+		}
+	}
+	return nil
+}
+
+// loadFolderLayout sets folderLayout for the library: layout if one was
+// given with --layout, otherwise the layout saved in _Manifest/ by an
+// earlier run, otherwise the default.
+func loadFolderLayout(layout string) error {
+	if layout != "" {
+		if err := parseLayout(layout); err != nil {
+			return fmt.Errorf("--layout: %v", err)
+		}
+		folderLayout = layout
+		return nil
+	}
+
+	layout = savedFolderLayout()
+	if err := parseLayout(layout); err != nil {
+		return fmt.Errorf("%s: %v", displayPath(layoutFile), err)
+	}
+	folderLayout = layout
+	return nil
+}
+
+// savedFolderLayout returns the layout saved in _Manifest/, or the default
+// layout if none was saved.
+func savedFolderLayout() string {
+	data, err := os.ReadFile(layoutFile)
+	if err != nil {
+		return defaultFolderLayout
+	}
+	if layout := strings.TrimSpace(string(data)); layout != "" {
+		return layout
+	}
+	return defaultFolderLayout
+}
+
+// saveFolderLayout saves folderLayout in _Manifest/ if it differs from the
+// saved one, so later runs and fsck use it without --layout. Called by runs
+// that execute, with the library lock held.
+func saveFolderLayout() error {
+	saved := savedFolderLayout()
+	if folderLayout == saved {
+		return nil
+	}
+	if _, err := os.Stat(layoutFile); err == nil {
+		logf(levelWarn, "Warning: folder layout changed from %q to %q; files already organized stay where they are, and fsck reports them as misplaced\n",
+			saved, folderLayout)
+	}
+	if err := os.MkdirAll(manifestDir, 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(layoutFile, 0644, func(w io.Writer) error {
+		_, err := fmt.Fprintln(w, folderLayout)
+		return err
+	})
+}
+
+// layoutUsesPlace reports whether the folder layout needs place names.
+func layoutUsesPlace() bool {
+	for _, t := range placeTokens {
+		if strings.Contains(folderLayout, "{"+t+"}") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPlaceLayout warns if the folder layout uses place names but no
+// GeoNames dump is installed to look them up.
+func checkPlaceLayout() {
+	if layoutUsesPlace() && loadGeocoder() == nil {
+		logf(levelWarn, "Warning: --layout uses place names, but no GeoNames cities file was found in %s; place tokens will be empty\n",
+			displayPath(geonamesDir))
+	}
+}
+
+// expandLayout returns the folder path for m under the folder layout.
+// Tokens without a value are dropped along with the separators around them,
+// so "{date} {city}" is just the date when the city is unknown. Values are
+// made safe as folder names.
+func expandLayout(m fileMeta) string {
+	var place placeName
+	if layoutUsesPlace() {
+		place = reverseGeocode(m.GPS)
+	}
+	return expandLayoutPlace(m, place)
+}
+
+// expandLayoutPlace is expandLayout for a file whose place is already known.
+func expandLayoutPlace(m fileMeta, place placeName) string {
+	var folders []string
+	for _, segment := range strings.Split(folderLayout, "/") {
+		for token, value := range layoutTokens {
+			segment = strings.ReplaceAll(segment, "{"+token+"}", folderSafe(value(m, place)))
+		}
+		segment = strings.Join(strings.Fields(segment), " ")
+		segment = strings.Trim(segment, " -_,")
+		if segment == "" {
+			segment = "Unknown"
+		}
+		folders = append(folders, segment)
+	}
+	return filepath.Join(folders...)
+}
+
+// folderSafe replaces characters that can't appear in a folder name on
+// common filesystems.
+func folderSafe(s string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+			return '-'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// GPX Track Logs
+// =============================================================================
+
+// trackPoint is one timed position from a GPX track log.
+type trackPoint struct {
+	Time time.Time
+	Pos  gpsPosition
+}
+
+// gpxTrackLog is the part of a GPX document geotag reads: the points of
+// every track segment. Waypoints and routes have no times and are ignored.
+type gpxTrackLog struct {
+	Tracks []struct {
+		Segments []struct {
+			Points []struct {
+				Lat  float64  `xml:"lat,attr"`
+				Lon  float64  `xml:"lon,attr"`
+				Ele  *float64 `xml:"ele"`
+				Time string   `xml:"time"`
+			} `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+// readTrackLogs reads the timed track points of the GPX files at paths,
+// sorted by time. Points without a time or a valid position are skipped.
+func readTrackLogs(paths []string) ([]trackPoint, error) {
+	var points []trackPoint
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var doc gpxTrackLog
+		if err := xml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		for _, trk := range doc.Tracks {
+			for _, seg := range trk.Segments {
+				for _, pt := range seg.Points {
+					t, err := time.Parse(time.RFC3339, pt.Time)
+					pos := newGPSPosition(pt.Lat, pt.Lon)
+					if err != nil || pos == nil {
+						continue
+					}
+					pos.Alt = pt.Ele
+					points = append(points, trackPoint{Time: t, Pos: *pos})
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Time.Before(points[j].Time)
+	})
+	return points, nil
+}
+
+// trackPosition returns where the track was at t, interpolated between the
+// points either side of it. Returns nil if t is outside the track, or the
+// points either side are more than maxGap apart (the logger was off).
+func trackPosition(points []trackPoint, t time.Time, maxGap time.Duration) *gpsPosition {
+	i := sort.Search(len(points), func(i int) bool {
+		return !points[i].Time.Before(t)
+	})
+	if i < len(points) && points[i].Time.Equal(t) {
+		p := points[i].Pos
+		return &p
+	}
+	if i == 0 || i == len(points) {
+		return nil
+	}
+
+	a, b := points[i-1], points[i]
+	span := b.Time.Sub(a.Time)
+	if span > maxGap {
+		return nil
+	}
+	frac := float64(t.Sub(a.Time)) / float64(span)
+
+	// Take the short way across the antimeridian
+	dLon := b.Pos.Lon - a.Pos.Lon
+	if dLon > 180 {
+		dLon -= 360
+	} else if dLon < -180 {
+		dLon += 360
+	}
+	lon := a.Pos.Lon + dLon*frac
+	if lon > 180 {
+		lon -= 360
+	} else if lon < -180 {
+		lon += 360
+	}
+
+	p := newGPSPosition(a.Pos.Lat+(b.Pos.Lat-a.Pos.Lat)*frac, lon)
+	if p != nil && a.Pos.Alt != nil && b.Pos.Alt != nil {
+		alt := roundTo(*a.Pos.Alt+(*b.Pos.Alt-*a.Pos.Alt)*frac, 1)
+		p.Alt = &alt
+	}
+	return p
+}
+
+// =============================================================================
+// XMP Sidecars
+// =============================================================================
+
+// xmpCreatorTool marks sidecars written by geotag, which it may rewrite.
+// Sidecars written by anything else are never touched.
+const xmpCreatorTool = `xmp:CreatorTool="photo-organizer"`
+
+// errForeignSidecar is returned by writeXMPSidecar when the file already has
+// a sidecar that geotag didn't write.
+var errForeignSidecar = errors.New("sidecar exists and was not written by photo-organizer")
+
+// xmpSidecarPath returns the sidecar path for a file: IMG_0412.NEF.xmp.
+// Keeping the extension avoids clashes between RAW and JPEG pairs.
+func xmpSidecarPath(path string) string {
+	return path + ".xmp"
+}
+
+// writeXMPSidecar writes an XMP sidecar holding p next to path. The file
+// itself is never modified.
+func writeXMPSidecar(path string, p gpsPosition) error {
+	sidecar := xmpSidecarPath(path)
+	if data, err := os.ReadFile(sidecar); err == nil && !bytes.Contains(data, []byte(xmpCreatorTool)) {
+		return errForeignSidecar
+	}
+
+	return writeFileAtomic(sidecar, 0644, func(w io.Writer) error {
+		alt := ""
+		if p.Alt != nil {
+			ref := 0
+			if *p.Alt < 0 {
+				ref = 1 // Below sea level
+			}
+			alt = fmt.Sprintf("\n   exif:GPSAltitudeRef=\"%d\"\n   exif:GPSAltitude=\"%d/10\"",
+				ref, int64(math.Round(math.Abs(*p.Alt)*10)))
+		}
+		_, err := fmt.Fprintf(w, `<?xpacket begin="%s" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/" x:xmptk="photo-organizer %s">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:exif="http://ns.adobe.com/exif/1.0/"
+   %s
+   exif:GPSVersionID="2.2.0.0"
+   exif:GPSLatitude="%s"
+   exif:GPSLongitude="%s"%s/>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>
+`, "\ufeff", version, xmpCreatorTool, xmpCoordinate(p.Lat, 'N', 'S'), xmpCoordinate(p.Lon, 'E', 'W'), alt)
+		return err
+	})
+}
+
+// sidecarRecord builds the manifest record for the sidecar at path, written
+// for the file r records. Sidecars are tracked like any other file in
+// Originals/, with r's capture date so fsck and reindex agree on the folder
+// they belong in. Fields of an existing entry that only organize knows are
+// kept.
+func sidecarRecord(path string, r manifestRecord, existing map[string]manifestRecord) (manifestRecord, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return manifestRecord{}, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return manifestRecord{}, err
+	}
+	hash := partialHash(f)
+	f.Close()
+
+	s := adoptedRecord(fileMeta{
+		Path:        path,
+		Size:        info.Size(),
+		ModTime:     info.ModTime(),
+		CaptureDate: r.CaptureDate,
+		DateSource:  r.DateSource,
+		Hash:        hash,
+	})
+	if e, ok := existing[s.RelativePath]; ok {
+		s.SourceFolder, s.OrganizedDate, s.SourcePath = e.SourceFolder, e.OrganizedDate, e.SourcePath
+	}
+	return s, nil
+}
+
+// xmpCoordinate formats decimal degrees as an XMP GPS coordinate, degrees
+// and decimal minutes followed by the hemisphere: "40,46.2203N".
+func xmpCoordinate(deg float64, pos, neg byte) string {
+	ref := pos
+	if deg < 0 {
+		ref = neg
+	}
+	deg = math.Abs(deg)
+	whole := math.Floor(deg)
+	return fmt.Sprintf("%d,%.4f%c", int(whole), (deg-whole)*60, ref)
+}
+
+// =============================================================================
+// Geotagging
+// =============================================================================
+
+// runGeotag implements the geotag subcommand: give library files without a
+// position the one a GPX track log had at their capture time, corrected by
+// the camera's clock offset. Positions go into the manifest and, with
+// --xmp, into sidecars next to the files. Originals are never modified.
+// Previews by default; -x writes.
+func runGeotag(args []string) int {
+	fs := flag.NewFlagSet("geotag", flag.ExitOnError)
+	rootDir := fs.String("root", "", "Photo library root directory (default: current directory)")
+	gpxFlag := fs.String("gpx", "", "GPX track logs, comma-separated (required)")
+	offset := fs.Duration("offset", 0, "How far the camera clock was ahead of the true time (e.g. 1h2m, -45s)")
+	tzFlag := fs.String("tz", "", "Time zone the camera clock was set to, e.g. Europe/Lisbon (default: local)")
+	maxGap := fs.Duration("max-gap", 10*time.Minute, "Longest gap between track points to interpolate across")
+	from := fs.String("from", "", "Captured on or after this date: YYYY, YYYY-MM or YYYY-MM-DD")
+	to := fs.String("to", "", "Captured on or before this date (the whole year, month or day)")
+	overwrite := fs.Bool("overwrite", false, "Replace positions files already have")
+	xmpFlag := fs.Bool("xmp", false, "Also write the position to an XMP sidecar next to each file")
+	execute := fs.Bool("execute", false, "Write the positions (default is to preview)")
+	executeShort := fs.Bool("x", false, "Write the positions (short for --execute)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Add GPS positions from a GPX track log to the library\n\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "  %s geotag --gpx track.gpx [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s geotag --gpx walk.gpx --from 2024-06-01 --to 2024-06-14\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s geotag --gpx walk.gpx --offset 1m30s --xmp -x\n", os.Args[0])
+	}
+	fs.Parse(args)
+
+	gpxPaths := splitList(*gpxFlag)
+	if fs.NArg() != 0 || len(gpxPaths) == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	dryRun := !*execute && !*executeShort
+
+	usageError := func(err error) int {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitUsage
+	}
+
+	loc := time.Local
+	if *tzFlag != "" {
+		var err error
+		if loc, err = time.LoadLocation(*tzFlag); err != nil {
+			return usageError(fmt.Errorf("--tz: %v", err))
+		}
+	}
+	q := &manifestQuery{}
+	var err error
+	if *from != "" {
+		if q.from, _, err = parseQueryDate(*from); err != nil {
+			return usageError(err)
+		}
+	}
+	if *to != "" {
+		if _, q.to, err = parseQueryDate(*to); err != nil {
+			return usageError(err)
+		}
+	}
+
+	points, err := readTrackLogs(gpxPaths)
+	if err != nil {
+		fmt.Println("Error reading track log:", err)
+		return exitFatal
+	}
+	if len(points) == 0 {
+		fmt.Println("Error: no timed track points in", *gpxFlag)
+		return exitFatal
+	}
+
+	if err := setLibraryPaths(*rootDir); err != nil {
+		fmt.Println("Error getting current directory:", err)
+		return exitFatal
+	}
+
+	if !dryRun {
+		lock, err := acquireLibraryLock(0)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return exitFatal
+		}
+		defer lock.release()
+	}
+
+	manifest, err := openManifest()
+	if err != nil {
+		fmt.Println("Error opening manifest:", err)
+		return exitFatal
+	}
+	defer manifest.close()
+
+	recs, err := manifest.records()
+	if err != nil {
+		fmt.Println("Error reading manifest:", err)
+		return exitFatal
+	}
+	byPath := make(map[string]manifestRecord, len(recs))
+	for _, r := range recs {
+		byPath[r.RelativePath] = r
+	}
+
+	if dryRun {
+		fmt.Println("=== DRY RUN MODE (use -x to write the positions) ===")
+	}
+	first, last := points[0].Time, points[len(points)-1].Time
+	fmt.Printf("Track: %d points, %s to %s\n\n", len(points),
+		first.In(loc).Format("2006-01-02 15:04:05"), last.In(loc).Format("2006-01-02 15:04:05 MST"))
+
+	var changes []manifestRecord
+	tagged, located, undated, outside, failed := 0, 0, 0, 0, 0
+	for _, r := range recs {
+		if sidecarExts[strings.ToLower(r.Extension)] || !q.match(r) {
+			continue
+		}
+		if r.GPS != nil && !*overwrite {
+			located++
+			continue
+		}
+		// Modification times and the current time say nothing about
+		// when the photo was taken, and filename dates have no time of day
+		switch r.DateSource {
+		case dateSourceFilename, dateSourceMtime, dateSourceNow:
+			undated++
+			continue
+		}
+		if r.CaptureDate.IsZero() {
+			undated++
+			continue
+		}
+
+		c := r.CaptureDate
+		taken := time.Date(c.Year(), c.Month(), c.Day(), c.Hour(), c.Minute(), c.Second(), c.Nanosecond(), loc).Add(-*offset)
+		pos := trackPosition(points, taken, *maxGap)
+		if pos == nil {
+			outside++
+			continue
+		}
+
+		r.GPS = pos
+		r.Place = reverseGeocode(pos)
+		desc := fmt.Sprintf("%.6f, %.6f", pos.Lat, pos.Lon)
+		if place := r.Place.String(); place != "" {
+			desc += " (" + place + ")"
+		}
+		fmt.Printf("  ✓ %s → %s\n", r.RelativePath, desc)
+		tagged++
+		changes = append(changes, r)
+
+		if dryRun || !*xmpFlag {
+			continue
+		}
+		path := filepath.Join(photoRoot, r.RelativePath)
+		if err := writeXMPSidecar(path, *pos); err != nil {
+			fmt.Printf("  ✗ %s (%v)\n", displayPath(xmpSidecarPath(path)), err)
+			failed++
+			continue
+		}
+		s, err := sidecarRecord(xmpSidecarPath(path), r, byPath)
+		if err != nil {
+			fmt.Printf("  ✗ %s (cannot read: %v)\n", displayPath(xmpSidecarPath(path)), err)
+			failed++
+			continue
+		}
+		changes = append(changes, s)
+	}
+
+	if tagged > 0 {
+		fmt.Println()
+	}
+	fmt.Printf("Tagged:          %d files\n", tagged)
+	fmt.Printf("Already located: %d files (use --overwrite to replace)\n", located)
+	fmt.Printf("Outside track:   %d files\n", outside)
+	fmt.Printf("No capture time: %d files\n", undated)
+	if failed > 0 {
+		fmt.Printf("Failed:          %d sidecars\n", failed)
+	}
+	if tagged == 0 && outside > 0 {
+		fmt.Println("\nNo capture times fall within the track; check --offset and --tz")
+	}
+
+	if !dryRun && len(changes) > 0 {
+		if err := manifest.update(changes); err != nil {
+			fmt.Println("Error updating manifest:", err)
+			return exitFatal
+		}
+		fmt.Printf("\nManifest updated with %d entries\n", len(changes))
+	}
+
+	if failed > 0 {
+		return exitPartial
+	}
+	return exitOK
+}
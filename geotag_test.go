@@ -0,0 +1,121 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testTrack builds a track from positions one minute apart, starting at start.
+func testTrack(start time.Time, positions ...gpsPosition) []trackPoint {
+	var points []trackPoint
+	for i, p := range positions {
+		points = append(points, trackPoint{Time: start.Add(time.Duration(i) * time.Minute), Pos: p})
+	}
+	return points
+}
+
+func TestTrackPosition(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	alt := func(v float64) *float64 { return &v }
+
+	lisbon := testTrack(start,
+		gpsPosition{Lat: 38.70, Lon: -9.14, Alt: alt(10)},
+		gpsPosition{Lat: 38.72, Lon: -9.10, Alt: alt(30)},
+		gpsPosition{Lat: 38.74, Lon: -9.10},
+	)
+	fiji := testTrack(start,
+		gpsPosition{Lat: -17.0, Lon: 179.8},
+		gpsPosition{Lat: -17.2, Lon: -179.8},
+	)
+	gappy := []trackPoint{
+		{Time: start, Pos: gpsPosition{Lat: 1, Lon: 1}},
+		{Time: start.Add(30 * time.Minute), Pos: gpsPosition{Lat: 2, Lon: 2}},
+	}
+
+	tests := []struct {
+		name   string
+		points []trackPoint
+		at     time.Time
+		want   *gpsPosition // nil if no position
+	}{
+		{"exact point", lisbon, start.Add(time.Minute), &gpsPosition{Lat: 38.72, Lon: -9.10, Alt: alt(30)}},
+		{"first point", lisbon, start, &gpsPosition{Lat: 38.70, Lon: -9.14, Alt: alt(10)}},
+		{"last point", lisbon, start.Add(2 * time.Minute), &gpsPosition{Lat: 38.74, Lon: -9.10}},
+		{"halfway", lisbon, start.Add(30 * time.Second), &gpsPosition{Lat: 38.71, Lon: -9.12, Alt: alt(20)}},
+		{"quarter way", lisbon, start.Add(15 * time.Second), &gpsPosition{Lat: 38.705, Lon: -9.13, Alt: alt(15)}},
+		{"no altitude at one end", lisbon, start.Add(90 * time.Second), &gpsPosition{Lat: 38.73, Lon: -9.10}},
+		{"before the track", lisbon, start.Add(-time.Second), nil},
+		{"after the track", lisbon, start.Add(2*time.Minute + time.Second), nil},
+		{"empty track", nil, start, nil},
+		{"across the antimeridian", fiji, start.Add(30 * time.Second), &gpsPosition{Lat: -17.1, Lon: 180}},
+		{"antimeridian, east side", fiji, start.Add(15 * time.Second), &gpsPosition{Lat: -17.05, Lon: 179.9}},
+		{"antimeridian, west side", fiji, start.Add(45 * time.Second), &gpsPosition{Lat: -17.15, Lon: -179.9}},
+		{"across a gap", gappy, start.Add(15 * time.Minute), nil},
+		{"at a point before a gap", gappy, start, &gpsPosition{Lat: 1, Lon: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := trackPosition(tt.points, tt.at, 10*time.Minute)
+			if tt.want == nil {
+				if got != nil {
+					t.Fatalf("trackPosition = %+v, want nil", *got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatalf("trackPosition = nil, want %+v", *tt.want)
+			}
+
+			// 180 and -180 are the same meridian
+			lonDiff := math.Mod(math.Abs(got.Lon-tt.want.Lon), 360)
+			if math.Abs(got.Lat-tt.want.Lat) > 1e-9 || math.Min(lonDiff, 360-lonDiff) > 1e-9 {
+				t.Errorf("trackPosition = %.6f, %.6f, want %.6f, %.6f", got.Lat, got.Lon, tt.want.Lat, tt.want.Lon)
+			}
+			if got.Lon < -180 || got.Lon > 180 {
+				t.Errorf("longitude %f is out of range", got.Lon)
+			}
+			switch {
+			case (got.Alt == nil) != (tt.want.Alt == nil):
+				t.Errorf("altitude = %v, want %v", got.Alt, tt.want.Alt)
+			case got.Alt != nil && *got.Alt != *tt.want.Alt:
+				t.Errorf("altitude = %v, want %v", *got.Alt, *tt.want.Alt)
+			}
+		})
+	}
+}
+
+func TestReadTrackLogs(t *testing.T) {
+	dir := t.TempDir()
+	gpx := `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <wpt lat="1" lon="1"><name>ignored</name></wpt>
+  <trk><trkseg>
+    <trkpt lat="38.72" lon="-9.10"><ele>30</ele><time>2024-06-01T12:01:00Z</time></trkpt>
+    <trkpt lat="38.70" lon="-9.14"><time>2024-06-01T12:00:00Z</time></trkpt>
+    <trkpt lat="38.71" lon="-9.12"></trkpt>
+    <trkpt lat="95" lon="0"><time>2024-06-01T12:02:00Z</time></trkpt>
+  </trkseg></trk>
+</gpx>`
+	path := filepath.Join(dir, "track.gpx")
+	if err := os.WriteFile(path, []byte(gpx), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	points, err := readTrackLogs([]string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 {
+		t.Fatalf("got %d points, want 2 (untimed and invalid points skipped)", len(points))
+	}
+	if !points[0].Time.Before(points[1].Time) || points[0].Pos.Lat != 38.70 {
+		t.Errorf("points not sorted by time: %+v", points)
+	}
+	if points[1].Pos.Alt == nil || *points[1].Pos.Alt != 30 {
+		t.Errorf("elevation not read: %+v", points[1].Pos)
+	}
+}
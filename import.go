@@ -0,0 +1,196 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// =============================================================================
+// Import From Camera Card
+// =============================================================================
+
+// unsafeLabelChars matches characters not allowed in an import label.
+var unsafeLabelChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// defaultImportLabel derives an import label from the source path, using the
+// card's mount point name (e.g. /media/EOS_DIGITAL -> EOS_DIGITAL) rather
+// than its DCIM folder.
+func defaultImportLabel(src string) string {
+	name := filepath.Base(src)
+	if strings.EqualFold(name, "DCIM") {
+		name = filepath.Base(filepath.Dir(src))
+	}
+	return sanitizeLabel(name)
+}
+
+// sanitizeLabel makes a label safe to use in a file name.
+func sanitizeLabel(label string) string {
+	label = strings.Trim(unsafeLabelChars.ReplaceAllString(label, "_"), "_.")
+	if label == "" {
+		return "card"
+	}
+	return label
+}
+
+// runImport implements the import subcommand: copy media straight from a
+// camera card (or any folder) into the Originals layout.
+// The source is only ever read. Each copy is verified before it counts as
+// imported, and a per-card import record makes re-inserting the same card
+// import only the files added since.
+// Returns the process exit code (see exitOK).
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	from := fs.String("from", "", "Card or folder to import from, e.g. /media/card (required)")
+	label := fs.String("label", "", "Name for the card's import record and manifest source_folder (default: card volume name)")
+	rootDir := fs.String("root", "", "Photo library root directory (default: current directory)")
+	execute := fs.Bool("execute", false, "Actually copy files (default is dry-run)")
+	executeShort := fs.Bool("x", false, "Actually copy files (short for --execute)")
+	updateManifestFlag := fs.Bool("update-manifest", false, "Update the manifest CSV after importing")
+	updateManifestShort := fs.Bool("m", false, "Update manifest (short for --update-manifest)")
+	reserveFlag := fs.String("reserve", "1GB", "Free space to keep on the destination filesystem (e.g. 500MB, 10GB)")
+	fs.IntVar(&jobs, "jobs", jobs, "Number of files to process in parallel")
+	noCache := fs.Bool("no-cache", false, "Don't read or update the metadata cache")
+	waitFlag := fs.Duration("wait", 0, "Wait up to this long if another run has the library locked (e.g. 10m)")
+	layoutFlag := fs.String("layout", "", layoutFlagUsage)
+	logOpts := addLogFlags(fs)
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Import directly from a camera card or folder (read-only)\n\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "  %s import --from <path> [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s import --from /media/card          # Preview\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s import --from /media/card -x -m    # Import and update manifest\n", os.Args[0])
+	}
+	fs.Parse(args)
+
+	if *from == "" {
+		fmt.Fprintln(os.Stderr, "Error: --from is required")
+		fs.Usage()
+		return exitUsage
+	}
+
+	if err := logOpts.apply(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitUsage
+	}
+
+	dryRun := !(*execute || *executeShort)
+	doUpdateManifest := *updateManifestFlag || *updateManifestShort
+
+	var err error
+	spaceReserve, err = parseSize(*reserveFlag)
+	if err != nil {
+		logf(levelError, "Error: --reserve: %v\n", err)
+		return exitFatal
+	}
+
+	if err := setLibraryPaths(*rootDir); err != nil {
+		logf(levelError, "Error getting current directory: %v\n", err)
+		return exitFatal
+	}
+	if err := loadFolderLayout(*layoutFlag); err != nil {
+		logf(levelError, "Error: %v\n", err)
+		return exitFatal
+	}
+	if err := openRunLog("import"); err != nil {
+		logf(levelWarn, "Warning: could not open run log: %v\n", err)
+	}
+	checkPlaceLayout()
+
+	src, err := filepath.Abs(*from)
+	if err != nil {
+		logf(levelError, "Error: %v\n", err)
+		return exitFatal
+	}
+	if info, err := os.Stat(src); err != nil || !info.IsDir() {
+		logf(levelError, "Error: import source not found at %s\n", src)
+		return exitFatal
+	}
+
+	if !*noCache {
+		metaCache = loadMetadataCache(cacheFile)
+		defer saveMetadataCache()
+	}
+
+	cardLabel := sanitizeLabel(*label)
+	if *label == "" {
+		cardLabel = defaultImportLabel(src)
+	}
+	recordFile := filepath.Join(importsDir, "card-"+cardLabel+".csv")
+
+	// Print banner
+	logf(levelInfo, "%s\n", strings.Repeat("=", 50))
+	logf(levelInfo, "Photo Organizer - Import\n")
+	logf(levelInfo, "%s\n", strings.Repeat("=", 50))
+	logf(levelInfo, "Source:    %s (read-only)\n", src)
+	logf(levelInfo, "Label:     %s\n", cardLabel)
+	logf(levelInfo, "Originals: %s\n", originalsDir)
+	logf(levelInfo, "\n")
+
+	if dryRun {
+		logf(levelInfo, "[DRY RUN MODE - use --execute or -x to actually copy files]\n")
+		logf(levelInfo, "\n")
+	}
+
+	var lock *libraryLock
+	if !dryRun {
+		lock, err = acquireLibraryLock(*waitFlag)
+		if err != nil {
+			logf(levelError, "Error: %v\n", err)
+			return exitFatal
+		}
+		defer lock.release()
+		if err := saveFolderLayout(); err != nil {
+			logf(levelError, "Error saving folder layout: %v\n", err)
+			return exitFatal
+		}
+	}
+
+	ledger, err := loadImportLedger(recordFile)
+	if err != nil {
+		logf(levelError, "Error: %v\n", err)
+		return exitFatal
+	}
+
+	files, err := findMediaFiles(src)
+	if err != nil {
+		logf(levelError, "Error scanning source: %v\n", err)
+		return exitFatal
+	}
+	if len(files) == 0 {
+		logf(levelInfo, "No media files found in %s\n", src)
+		return exitNothingToDo
+	}
+	logf(levelInfo, "Found %d files on source\n\n", len(files))
+
+	plan := buildPlan(extractMetadata(src, files, ledger))
+	if err := checkFreeSpace(plan, modeCopy, dryRun); err != nil {
+		logf(levelError, "Error: %v\n", err)
+		return exitFatal
+	}
+
+	organized := executePlan(plan, modeCopy, dryRun, ledger, src, func(string) string { return cardLabel })
+	if !dryRun {
+		if err := ledger.save(); err != nil {
+			logf(levelError, "Error saving import record: %v\n", err)
+			recordFailure(recordFile, "save import record", err)
+		}
+		if len(organized) > 0 && doUpdateManifest {
+			if err := updateManifest(organized); err != nil {
+				logf(levelError, "Error updating manifest: %v\n", err)
+				recordFailure(manifestFile, "update manifest", err)
+			}
+		}
+	}
+
+	code := finishRun()
+	logf(levelInfo, "\nDone!\n")
+	return code
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// =============================================================================
+// Import Ledger
+// =============================================================================
+
+// importEntry records one source file that was imported into Originals.
+type importEntry struct {
+	SourcePath string    // Path relative to the source root
+	Size       int64     // Source size at import time
+	ModTime    time.Time // Source modification time at import time
+	DestPath   string    // Destination relative to the photo root
+	ImportedAt time.Time // When the file was imported
+}
+
+// importLedger tracks files that were imported without being removed from
+// their source (copy, hardlink and reflink modes). A file whose size and
+// modification time still match its ledger entry is skipped on later runs.
+type importLedger struct {
+	path    string
+	entries map[string]importEntry
+	dirty   bool
+}
+
+// importLedgerHeaders are the CSV columns of an import ledger file.
+var importLedgerHeaders = []string{
+	"source_path",
+	"file_size_bytes",
+	"file_modified",
+	"dest_path",
+	"imported_date",
+}
+
+// loadImportLedger reads the ledger at path.
+// A missing file yields an empty ledger.
+func loadImportLedger(path string) (*importLedger, error) {
+	l := &importLedger{path: path, entries: make(map[string]importEntry)}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("reading import ledger %s: %v", path, err)
+	}
+
+	for i, row := range records {
+		if i == 0 || len(row) < len(importLedgerHeaders) {
+			continue // Header or malformed row
+		}
+		size, _ := strconv.ParseInt(row[1], 10, 64)
+		modTime, _ := time.Parse(time.RFC3339Nano, row[2])
+		importedAt, _ := time.Parse(time.RFC3339, row[4])
+		l.entries[row[0]] = importEntry{
+			SourcePath: row[0],
+			Size:       size,
+			ModTime:    modTime,
+			DestPath:   row[3],
+			ImportedAt: importedAt,
+		}
+	}
+
+	return l, nil
+}
+
+// contains returns true if the source file at rel was already imported and
+// its size and modification time have not changed since.
+func (l *importLedger) contains(rel string, size int64, modTime time.Time) bool {
+	e, ok := l.entries[filepath.ToSlash(rel)]
+	return ok && e.Size == size && e.ModTime.Equal(modTime)
+}
+
+// add records that the source file at rel, with the given size and
+// modification time, was imported to destPath.
+func (l *importLedger) add(rel string, size int64, modTime time.Time, destPath string) {
+	destRel, _ := filepath.Rel(photoRoot, destPath)
+	rel = filepath.ToSlash(rel)
+	l.entries[rel] = importEntry{
+		SourcePath: rel,
+		Size:       size,
+		ModTime:    modTime,
+		DestPath:   filepath.ToSlash(destRel),
+		ImportedAt: time.Now(),
+	}
+	l.dirty = true
+}
+
+// save writes the ledger back to disk if it changed.
+func (l *importLedger) save() error {
+	if !l.dirty {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+
+	var paths []string
+	for p := range l.entries {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	err := writeFileAtomic(l.path, 0644, func(w io.Writer) error {
+		writer := csv.NewWriter(w)
+		writer.Write(importLedgerHeaders)
+		for _, p := range paths {
+			e := l.entries[p]
+			writer.Write([]string{
+				e.SourcePath,
+				strconv.FormatInt(e.Size, 10),
+				e.ModTime.Format(time.RFC3339Nano),
+				e.DestPath,
+				e.ImportedAt.Format(time.RFC3339),
+			})
+		}
+		writer.Flush()
+		return writer.Error()
+	})
+	if err != nil {
+		return err
+	}
+
+	l.dirty = false
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// Library Lock
+// =============================================================================
+
+// lockPollInterval is how often a waiting run retries a busy lock.
+const lockPollInterval = 2 * time.Second
+
+// errLibraryBusy is returned when another run holds the library lock.
+var errLibraryBusy = errors.New("photo library is busy")
+
+// lockInfo describes the run holding the library lock.
+type lockInfo struct {
+	PID     int       // Process ID of the holder
+	Host    string    // Hostname of the holder
+	Started time.Time // When the holder acquired the lock
+}
+
+// String formats the holder for error messages.
+func (li lockInfo) String() string {
+	return fmt.Sprintf("pid %d on %s since %s", li.PID, li.Host, li.Started.Format("2006-01-02 15:04:05"))
+}
+
+// libraryLock is an advisory lock on a file in _Manifest/ that keeps two
+// runs from moving files or rewriting the manifest at the same time.
+// The lock itself is held by the operating system on the open file; the
+// file contents only describe the holder for error messages.
+type libraryLock struct {
+	file *os.File
+}
+
+// acquireLibraryLock takes the library lock.
+// Locks held by crashed runs are released by the operating system, so there
+// is never a stale lock to remove.
+// If the library is busy, waits up to wait for it to become free before
+// giving up; a zero wait fails immediately.
+func acquireLibraryLock(wait time.Duration) (*libraryLock, error) {
+	if err := os.MkdirAll(manifestDir, 0755); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(wait)
+	announced := false
+
+	for {
+		lock, holder, err := tryLock(lockFile)
+		if err != nil || lock != nil {
+			return lock, err
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w: locked by %s\n"+
+				"Use --wait to wait for it to finish",
+				errLibraryBusy, holder)
+		}
+
+		if !announced {
+			logf(levelInfo, "Library is locked by %s, waiting...\n", holder)
+			announced = true
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// tryLock makes one attempt to lock the lock file.
+// Returns the lock on success, or the current holder if it is busy.
+func tryLock(path string) (*libraryLock, lockInfo, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, lockInfo{}, err
+	}
+
+	ok, err := lockHandle(f)
+	if err != nil || !ok {
+		f.Close()
+		if err != nil {
+			return nil, lockInfo{}, err
+		}
+		holder, _ := readLockInfo(path)
+		return nil, holder, nil
+	}
+
+	host, _ := os.Hostname()
+	info := fmt.Sprintf("pid=%d\nhost=%s\nstarted=%s\n",
+		os.Getpid(), host, time.Now().Format(time.RFC3339))
+	if err = f.Truncate(0); err == nil {
+		_, err = f.WriteAt([]byte(info), 0)
+	}
+	if err != nil {
+		unlockHandle(f)
+		f.Close()
+		return nil, lockInfo{}, err
+	}
+	return &libraryLock{file: f}, lockInfo{}, nil
+}
+
+// readLockInfo parses the lock file at path.
+// Fields that cannot be parsed are left zero.
+func readLockInfo(path string) (lockInfo, error) {
+	var li lockInfo
+
+	f, err := os.Open(path)
+	if err != nil {
+		return li, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		switch key {
+		case "pid":
+			li.PID, _ = strconv.Atoi(value)
+		case "host":
+			li.Host = value
+		case "started":
+			li.Started, _ = time.Parse(time.RFC3339, value)
+		}
+	}
+
+	if li.Started.IsZero() {
+		// Holder is still writing the file; fall back to its mtime
+		if info, err := f.Stat(); err == nil {
+			li.Started = info.ModTime()
+		}
+	}
+
+	return li, scanner.Err()
+}
+
+// release unlocks the library.
+// The lock file is left in place: removing it would let a waiting run lock
+// the old file while a new run creates and locks a fresh one.
+func (l *libraryLock) release() {
+	if l == nil {
+		return
+	}
+	l.file.Truncate(0)
+	unlockHandle(l.file)
+	l.file.Close()
+}
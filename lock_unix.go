@@ -0,0 +1,24 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockHandle takes an exclusive, non-blocking lock on f.
+// Returns false if another process holds it. The kernel drops the lock when
+// the holder exits, so a crashed run never leaves the library locked.
+func lockHandle(f *os.File) (bool, error) {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// unlockHandle releases a lock taken by lockHandle.
+func unlockHandle(f *os.File) {
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,48 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procLockFileEx   = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+	procUnlockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	errorLockViolation      = syscall.Errno(33)
+)
+
+// lockRegion is the byte range locked by lockHandle. Windows locks are
+// mandatory, so it lies far past the end of the file to leave the holder
+// details readable by waiting runs.
+var lockRegion = syscall.Overlapped{OffsetHigh: 0x40000000}
+
+// lockHandle takes an exclusive, non-blocking lock on f.
+// Returns false if another process holds it. Windows drops the lock when
+// the holder exits, so a crashed run never leaves the library locked.
+func lockHandle(f *os.File) (bool, error) {
+	ol := lockRegion
+	r, _, err := procLockFileEx.Call(f.Fd(),
+		lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0,
+		uintptr(unsafe.Pointer(&ol)))
+	if r != 0 {
+		return true, nil
+	}
+	if err == errorLockViolation {
+		return false, nil
+	}
+	return false, err
+}
+
+// unlockHandle releases a lock taken by lockHandle.
+func unlockHandle(f *os.File) {
+	ol := lockRegion
+	procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// =============================================================================
+// Run Logs
+// =============================================================================
+//
+// Every organize, import and apply run writes _Manifest/logs/run-<run-id>.log
+// with the tool version, the command line and root, and every message at or
+// above the file log level. What is shown on the console is controlled
+// separately with -q and -v.
+
+// version is the tool version, set at build time with
+// -ldflags "-X main.version=1.2.3".
+var version = "dev"
+
+// logLevel is the severity of a log message.
+type logLevel int
+
+const (
+	levelDebug logLevel = iota // Every decision, e.g. each step of the date chain
+	levelInfo                  // Progress and transfers
+	levelWarn                  // Fallbacks worth a look, e.g. a bad config file
+	levelError                 // Failed operations
+)
+
+// logLevelNames are the names of the log levels, indexed by level.
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+// String returns the level's name.
+func (l logLevel) String() string {
+	return logLevelNames[l]
+}
+
+// parseLogLevel parses a level name.
+func parseLogLevel(s string) (logLevel, error) {
+	for i, name := range logLevelNames {
+		if strings.EqualFold(s, name) {
+			return logLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q (expected %s)", s, strings.Join(logLevelNames, ", "))
+}
+
+var (
+	logMu        sync.Mutex
+	logFile      *os.File    // Current run log; nil if not logging to a file
+	logStarted   time.Time   // When the run log was opened
+	fileLevel    = levelInfo // Minimum level written to the run log
+	consoleLevel = levelInfo // Minimum level printed to the console
+	logKeep      = 30        // Number of run logs to keep in _Manifest/logs/
+)
+
+// logf prints a message on the console if level is at or above the console
+// level, and writes it to the run log if it is at or above the file level.
+// format is printed as is on the console; in the log, each non-blank line
+// gets a timestamp and the level. Safe for concurrent use.
+func logf(level logLevel, format string, args ...any) {
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	msg := fmt.Sprintf(format, args...)
+	if level >= consoleLevel {
+		fmt.Print(msg)
+	}
+	writeLogLines(level, msg)
+}
+
+// logFilef writes a message to the run log only, if level is at or above the
+// file level. Used for per-file details that would flood the console, which
+// gets a summary instead. Safe for concurrent use.
+func logFilef(level logLevel, format string, args ...any) {
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	writeLogLines(level, fmt.Sprintf(format, args...))
+}
+
+// writeLogLines writes each non-blank line of msg to the run log with a
+// timestamp and the level. The caller must hold logMu.
+func writeLogLines(level logLevel, msg string) {
+	if logFile == nil || level < fileLevel {
+		return
+	}
+
+	stamp := time.Now().Format("2006-01-02 15:04:05.000")
+	for _, line := range strings.Split(msg, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fmt.Fprintf(logFile, "%s %-5s %s\n", stamp, strings.ToUpper(level.String()), line)
+	}
+}
+
+// logOptions are the logging flags shared by the commands that write run
+// logs.
+type logOptions struct {
+	quiet   *bool
+	verbose *bool
+	level   *string
+}
+
+// addLogFlags registers the logging flags on fs.
+func addLogFlags(fs *flag.FlagSet) *logOptions {
+	o := &logOptions{
+		quiet:   fs.Bool("q", false, "Quiet: only show warnings and errors on the console"),
+		verbose: fs.Bool("v", false, "Verbose: also show debug messages (e.g. each date decision) on the console"),
+		level:   fs.String("log-level", "info", "Level written to the run log in _Manifest/logs/: debug, info, warn or error"),
+	}
+	fs.IntVar(&logKeep, "log-keep", logKeep, "Number of run logs to keep in _Manifest/logs/ (0 disables run logs)")
+	return o
+}
+
+// apply sets the console and file log levels from the flags.
+func (o *logOptions) apply() error {
+	level, err := parseLogLevel(*o.level)
+	if err != nil {
+		return err
+	}
+	fileLevel = level
+
+	switch {
+	case *o.quiet && *o.verbose:
+		return fmt.Errorf("-q and -v can't be used together")
+	case *o.quiet:
+		consoleLevel = levelWarn
+	case *o.verbose:
+		consoleLevel = levelDebug
+	}
+	return nil
+}
+
+// openRunLog starts the run log for command in _Manifest/logs/, recording the
+// version, command line and root, and removes logs beyond the retention
+// count. Does nothing if run logs are disabled.
+func openRunLog(command string) error {
+	if logKeep <= 0 {
+		return nil
+	}
+	if err := os.MkdirAll(logsDir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(logsDir, "run-"+runID+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	logMu.Lock()
+	logFile = f
+	logStarted = time.Now()
+	fmt.Fprintln(f, strings.Repeat("-", 50))
+	fmt.Fprintf(f, "photo-organizer %s - %s\n", version, command)
+	fmt.Fprintf(f, "Started:   %s\n", logStarted.Format(time.RFC3339))
+	fmt.Fprintf(f, "Command:   %s\n", strings.Join(os.Args, " "))
+	fmt.Fprintf(f, "Root:      %s\n", photoRoot)
+	fmt.Fprintf(f, "Log level: %s\n", fileLevel)
+	fmt.Fprintln(f, strings.Repeat("-", 50))
+	logMu.Unlock()
+
+	pruneLogs("run-", ".log")
+	pruneLogs("errors-", ".csv")
+	return nil
+}
+
+// closeRunLog finishes the run log with the exit code.
+func closeRunLog(code int) {
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	if logFile == nil {
+		return
+	}
+	fmt.Fprintln(logFile, strings.Repeat("-", 50))
+	fmt.Fprintf(logFile, "Finished in %s with exit code %d\n", time.Since(logStarted).Round(time.Millisecond), code)
+	logFile.Close()
+	logFile = nil
+}
+
+// exitRun closes the run log and exits with code.
+func exitRun(code int) {
+	closeRunLog(code)
+	os.Exit(code)
+}
+
+// pruneLogs removes the oldest files named prefix*suffix in the logs
+// directory, keeping logKeep of them.
+func pruneLogs(prefix, suffix string) {
+	entries, err := os.ReadDir(logsDir)
+	if err != nil {
+		return
+	}
+
+	// Run IDs sort lexically, so name order is age order
+	var logs []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), prefix) && strings.HasSuffix(e.Name(), suffix) {
+			logs = append(logs, e.Name())
+		}
+	}
+	sort.Strings(logs)
+
+	for len(logs) > logKeep {
+		os.Remove(filepath.Join(logsDir, logs[0]))
+		logs = logs[1:]
+	}
+}
@@ -9,7 +9,7 @@
 //   - Filename pattern recognition (DJI, Sony, etc.)
 //   - Duplicate detection via file size comparison
 //   - Manifest CSV tracking for all organized files
-//   - Cross-device file moving support
+//   - Verified, atomic cross-device file moves
 //   - Empty folder cleanup
 //
 // Usage:
@@ -18,6 +18,12 @@
 //	photo-organizer -x           # Execute file moves
 //	photo-organizer -x -m        # Execute and update manifest
 //	photo-organizer --root /path # Use custom root directory
+//	photo-organizer import --from /media/card -x  # Import from a camera card
+//	photo-organizer verify-source /media/card     # Check a card is safe to format
+//	photo-organizer fsck                          # Check the library against the manifest
+//	photo-organizer reindex -x                    # Rebuild the manifest from Originals
+//	photo-organizer query --ext nef --from 2024-06 --to 2024-06  # Search the manifest
+//	photo-organizer geotag --gpx track.gpx --offset 1m30s -x    # Geotag from a track log
 //
 // Expected directory structure:
 //
@@ -31,6 +37,7 @@ package main
 
 import (
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/csv"
 	"flag"
 	"fmt"
@@ -39,7 +46,9 @@ import (
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/rwcarlsen/goexif/exif"
@@ -56,8 +65,28 @@ var (
 	originalsDir string // Directory for organized original photos
 	manifestDir  string // Directory for manifest CSV
 	manifestFile string // Path to the manifest CSV file
+	catalogFile  string // Path to the SQLite catalog (replaces the CSV once migrated)
+	lockFile     string // Advisory lock held while a run modifies the library
+	backupsDir   string // Directory for rotating manifest backups
+	trashDir     string // Directory for leftovers removed from Incoming
+	cacheFile    string // Persistent metadata cache
+	logsDir      string // Directory for run logs and error reports
+	geonamesDir  string // GeoNames dumps for offline reverse geocoding
+	layoutFile   string // Folder layout saved by the last run that changed it
+
+	importsDir         string // Directory for import ledgers
+	incomingLedgerFile string // Ledger of files imported from Incoming without moving
 )
 
+// runID identifies the current run, e.g. in _Manifest/trash/<run-id>/.
+// The millisecond start time and PID keep it unique even when several runs
+// start in the same second.
+var runID = fmt.Sprintf("%s-%d", time.Now().Format("20060102-150405.000"), os.Getpid())
+
+// manifestBackups is the number of timestamped manifest backups to keep,
+// set by the --manifest-backups flag. Zero disables backups.
+var manifestBackups = 10
+
 // =============================================================================
 // Supported File Types
 // =============================================================================
@@ -149,12 +178,17 @@ var datePatterns = []struct {
 // FileInfo holds metadata about an organized file.
 // Used for manifest tracking and reporting.
 type FileInfo struct {
-	SrcPath     string    // Original path in Incoming/
-	DestPath    string    // New path in Originals/
-	Size        int64     // File size in bytes
-	ModTime     time.Time // File modification time
-	CaptureDate time.Time // Extracted capture date
-	Hash        string    // MD5 hash of first 64KB (for duplicate detection)
+	SrcPath      string       // Original path in Incoming/ (or import source)
+	SourceFolder string       // Top-level Incoming/ folder, or import label
+	DestPath     string       // New path in Originals/
+	Size         int64        // File size in bytes
+	ModTime      time.Time    // File modification time
+	CaptureDate  time.Time    // Extracted capture date
+	DateSource   string       // Where CaptureDate came from (dateSource*)
+	Camera       cameraInfo   // EXIF camera, lens and exposure
+	GPS          *gpsPosition // EXIF GPS position; nil if none
+	Video        videoInfo    // Container duration, frame rate and codec (videos)
+	Hash         string       // MD5 hash of first 64KB (for duplicate detection)
 }
 
 // =============================================================================
@@ -174,26 +208,52 @@ func isPhotoFile(ext string) bool {
 	return photoExts[strings.ToLower(ext)]
 }
 
+// isVideoFile returns true if the file extension indicates a video file.
+// Video files are candidates for container metadata extraction.
+func isVideoFile(ext string) bool {
+	return videoExts[strings.ToLower(ext)]
+}
+
 // =============================================================================
 // Date Extraction
 // =============================================================================
 
-// getExifDate extracts the capture date from a photo's EXIF metadata.
-// Returns the DateTimeOriginal field if available.
+// Date sources, recorded alongside a capture date to say where it came from.
+const (
+	dateSourceExif     = "exif"     // EXIF DateTimeOriginal
+	dateSourceFilename = "filename" // Parsed from the filename
+	dateSourceMtime    = "mtime"    // File modification time
+	dateSourceNow      = "now"      // Current time (nothing else available)
+	dateSourceManual   = "manual"   // Set by the user during review
+)
+
+// getExifData decodes a photo's EXIF metadata.
 // Returns an error if the file cannot be read or has no EXIF data.
-func getExifDate(path string) (time.Time, error) {
+func getExifData(path string) (*exif.Exif, error) {
 	f, err := os.Open(path)
 	if err != nil {
-		return time.Time{}, err
+		return nil, err
 	}
 	defer f.Close()
 
-	x, err := exif.Decode(f)
+	return exif.Decode(f)
+}
+
+// exifString returns a trimmed string EXIF field, or "" if x is nil or the
+// field is missing.
+func exifString(x *exif.Exif, name exif.FieldName) string {
+	if x == nil {
+		return ""
+	}
+	tag, err := x.Get(name)
 	if err != nil {
-		return time.Time{}, err
+		return ""
 	}
-
-	return x.DateTime()
+	s, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(s, "\x00"))
 }
 
 // getDateFromFilename attempts to extract a date from the filename.
@@ -212,35 +272,62 @@ func getDateFromFilename(filename string) (time.Time, bool) {
 	return time.Time{}, false
 }
 
-// getFileDate determines the best available date for a file.
+// resolveFileDate determines the best available date for a file the caller
+// has already examined, and also returns the date source used.
 // Priority:
 //  1. EXIF DateTimeOriginal (for photos)
 //  2. Date parsed from filename
 //  3. File modification time
 //  4. Current time (fallback)
-func getFileDate(path string) time.Time {
-	ext := filepath.Ext(path)
-	filename := filepath.Base(path)
+//
+// info may be nil if the file could not be stat'ed; x is its decoded EXIF,
+// or nil if it has none.
+func resolveFileDate(path string, info os.FileInfo, x *exif.Exif) (time.Time, string) {
+	name := displayPath(path)
 
 	// Try EXIF for photos
-	if isPhotoFile(ext) {
-		if t, err := getExifDate(path); err == nil {
-			return t
+	if x != nil {
+		t, err := x.DateTime()
+		if err == nil {
+			logf(levelDebug, "%s: date %s from EXIF\n", name, t.Format(time.RFC3339))
+			return t, dateSourceExif
 		}
+		logf(levelDebug, "%s: no EXIF date (%v)\n", name, err)
+	} else {
+		logf(levelDebug, "%s: no EXIF data\n", name)
 	}
 
 	// Try filename patterns
-	if t, ok := getDateFromFilename(filename); ok {
-		return t
+	if t, ok := getDateFromFilename(filepath.Base(path)); ok {
+		logf(levelDebug, "%s: date %s from filename\n", name, t.Format("2006-01-02"))
+		return t, dateSourceFilename
 	}
+	logf(levelDebug, "%s: no date in filename\n", name)
 
 	// Fall back to modification time
-	info, err := os.Stat(path)
-	if err == nil {
-		return info.ModTime()
+	if info != nil {
+		noteDateFallback(path, info.ModTime(), dateSourceMtime)
+		return info.ModTime(), dateSourceMtime
 	}
 
-	return time.Now()
+	noteDateFallback(path, time.Now(), dateSourceNow)
+	return time.Now(), dateSourceNow
+}
+
+// dateFallbacks counts the files of this run dated from their modification
+// time or the current time.
+var dateFallbacks atomic.Int64
+
+// noteDateFallback records that path was dated from source, its modification
+// time or the current time. This is common for videos, so each file is only
+// noted in the run log; finishRun prints the count on the console.
+func noteDateFallback(path string, t time.Time, source string) {
+	dateFallbacks.Add(1)
+	if source == dateSourceNow {
+		logFilef(levelInfo, "%s: no date available, using the current time\n", displayPath(path))
+		return
+	}
+	logFilef(levelInfo, "%s: no EXIF or filename date, using modification time %s\n", displayPath(path), t.Format(time.RFC3339))
 }
 
 // =============================================================================
@@ -250,32 +337,71 @@ func getFileDate(path string) time.Time {
 // getFileHash computes an MD5 hash of the first 64KB of a file.
 // This provides fast duplicate detection without reading entire files.
 // Returns an empty string if the file cannot be read.
+// Results are served from the metadata cache when the file is unchanged.
 func getFileHash(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	if e, ok := metaCache.lookup(path, info); ok && e.Hash != "" {
+		return e.Hash
+	}
+
 	f, err := os.Open(path)
 	if err != nil {
 		return ""
 	}
 	defer f.Close()
 
+	hash := partialHash(f)
+	metaCache.update(path, info, func(e *cacheEntry) { e.Hash = hash })
+	return hash
+}
+
+// partialHash computes an MD5 hash of the first 64KB read from r.
+func partialHash(r io.Reader) string {
 	h := md5.New()
 	buf := make([]byte, 65536)
-	n, _ := f.Read(buf)
+	n, _ := r.Read(buf)
 	h.Write(buf[:n])
 
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
+// hashFile computes a SHA-256 hash of a file's entire content.
+// Used to verify copies byte-for-byte.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
 // =============================================================================
 // File Discovery
 // =============================================================================
 
 // findFilesToOrganize walks the Incoming directory and returns paths to all
 // media files that should be organized.
-// Skips hidden files/folders and system directories defined in skipFolders.
 func findFilesToOrganize() ([]string, error) {
+	return findMediaFiles(incomingDir)
+}
+
+// findMediaFiles walks root and returns paths to all media files in it.
+// Skips hidden files/folders and system directories defined in skipFolders.
+// Never modifies anything under root.
+func findMediaFiles(root string) ([]string, error) {
 	var files []string
 
-	err := filepath.Walk(incomingDir, func(path string, info os.FileInfo, err error) error {
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return nil // Skip errors, continue walking
 		}
@@ -283,7 +409,7 @@ func findFilesToOrganize() ([]string, error) {
 		// Skip directories we don't want to process
 		if info.IsDir() {
 			name := info.Name()
-			if strings.HasPrefix(name, ".") || skipFolders[name] {
+			if path != root && (strings.HasPrefix(name, ".") || skipFolders[name]) {
 				return filepath.SkipDir
 			}
 			return nil
@@ -310,49 +436,95 @@ func findFilesToOrganize() ([]string, error) {
 // Path Generation
 // =============================================================================
 
-// getDestination calculates the destination path for a source file.
-// Organizes into: Originals/YYYY/YYYY-MM-DD/filename
-func getDestination(srcPath string) string {
-	fileDate := getFileDate(srcPath)
-	year := fileDate.Format("2006")
-	dateFolder := fileDate.Format("2006-01-02")
-	filename := filepath.Base(srcPath)
-
-	return filepath.Join(originalsDir, year, dateFolder, filename)
+// getDestination calculates the destination path for a source file from
+// its capture date, event label and place, following the folder layout.
+// By default organizes into: Originals/YYYY/YYYY-MM-DD[ Label]/filename
+func getDestination(m fileMeta) string {
+	return filepath.Join(originalsDir, expandLayout(m), filepath.Base(m.Path))
 }
 
 // =============================================================================
 // Core Organization Logic
 // =============================================================================
 
-// organizeFiles processes all files in Incoming and moves them to Originals.
-// If dryRun is true, only prints what would happen without moving files.
-// Returns a slice of FileInfo for successfully organized files.
-func organizeFiles(dryRun bool) ([]FileInfo, error) {
-	files, err := findFilesToOrganize()
-	if err != nil {
-		return nil, err
-	}
+// planAction says what a run will do with one source file.
+type planAction string
 
-	if len(files) == 0 {
-		fmt.Println("No new files found in Incoming/")
-		return nil, nil
+const (
+	actionTransfer  planAction = "transfer"  // Transfer to DestPath
+	actionDuplicate planAction = "duplicate" // Same name and size already at the destination
+	actionImported  planAction = "imported"  // Already imported by an earlier non-move run
+)
+
+// planEntry is the decision made for one source file.
+type planEntry struct {
+	SrcPath     string       // Source file
+	DestPath    string       // Destination in Originals/
+	Size        int64        // Source size in bytes
+	ModTime     time.Time    // Source modification time
+	CaptureDate time.Time    // Capture date the destination is based on
+	DateSource  string       // Where CaptureDate came from (dateSource*)
+	Camera      cameraInfo   // EXIF camera, lens and exposure
+	GPS         *gpsPosition // EXIF GPS position; nil if none
+	Video       videoInfo    // Container duration, frame rate and codec (videos)
+	Hash        string       // MD5 of the first 64KB
+	Action      planAction   // What to do with the file
+	Renamed     bool         // DestPath got a numeric suffix to avoid a name collision
+}
+
+// buildPlan decides what to do with each source file without touching the
+// library. Destinations claimed earlier in the plan count as existing files,
+// so collisions within one run resolve the same way every time.
+func buildPlan(metas []fileMeta) []planEntry {
+	var plan []planEntry
+	claimed := make(map[string]int64) // Destination -> size of the file claiming it
+
+	// destSize returns the size of whatever occupies path, on disk or in the plan
+	destSize := func(path string) (int64, bool) {
+		if size, ok := claimed[path]; ok {
+			return size, true
+		}
+		if info, err := os.Stat(path); err == nil {
+			return info.Size(), true
+		}
+		return 0, false
 	}
 
-	fmt.Printf("Found %d files to organize\n\n", len(files))
+	for _, m := range metas {
+		if m.Err != nil {
+			logf(levelError, "Error reading %s: %v\n", m.Path, m.Err)
+			recordFailure(m.Path, "read", m.Err)
+			continue
+		}
 
-	var organized []FileInfo
-	skipped := 0
+		entry := planEntry{
+			SrcPath:     m.Path,
+			Size:        m.Size,
+			ModTime:     m.ModTime,
+			CaptureDate: m.CaptureDate,
+			DateSource:  m.DateSource,
+			Camera:      m.Camera,
+			GPS:         m.GPS,
+			Video:       m.Video,
+			Hash:        m.Hash,
+			Action:      actionTransfer,
+		}
+
+		if m.Imported {
+			entry.Action = actionImported
+			plan = append(plan, entry)
+			continue
+		}
 
-	for _, srcPath := range files {
-		destPath := getDestination(srcPath)
+		destPath := getDestination(m)
 
 		// Check for existing file at destination
-		if destInfo, err := os.Stat(destPath); err == nil {
-			srcInfo, _ := os.Stat(srcPath)
+		if size, exists := destSize(destPath); exists {
 			// Skip if same size (likely duplicate)
-			if srcInfo.Size() == destInfo.Size() {
-				skipped++
+			if size == m.Size {
+				entry.DestPath = destPath
+				entry.Action = actionDuplicate
+				plan = append(plan, entry)
 				continue
 			}
 			// Different file with same name - add numeric suffix
@@ -361,72 +533,276 @@ func organizeFiles(dryRun bool) ([]FileInfo, error) {
 			counter := 1
 			for {
 				destPath = fmt.Sprintf("%s_%d%s", base, counter, ext)
-				if _, err := os.Stat(destPath); os.IsNotExist(err) {
+				if _, exists := destSize(destPath); !exists {
 					break
 				}
 				counter++
 			}
+			entry.Renamed = true
 		}
 
-		// Display relative paths for cleaner output
-		relSrc, _ := filepath.Rel(photoRoot, srcPath)
-		relDest, _ := filepath.Rel(photoRoot, destPath)
+		entry.DestPath = destPath
+		claimed[destPath] = m.Size
+		plan = append(plan, entry)
+	}
+
+	return plan
+}
+
+// organizeOptions controls an organize run.
+type organizeOptions struct {
+	DryRun  bool         // Only print what would happen
+	Mode    transferMode // How files get into Originals
+	PlanOut string       // Write the plan as JSON to this file ("" = don't)
+	Review  bool         // Review and adjust the plan interactively first
+}
+
+// organizeFiles processes all files in Incoming and transfers them to
+// Originals using the given mode.
+// The whole run is planned first; before executing, the plan is checked
+// against the free space on the destination filesystem.
+// If DryRun is set, only prints what would happen without moving files.
+// Returns a slice of FileInfo for successfully organized files.
+func organizeFiles(opts organizeOptions) ([]FileInfo, error) {
+	dryRun, mode := opts.DryRun, opts.Mode
+
+	files, err := findFilesToOrganize()
+	if err != nil {
+		return nil, err
+	}
+
+	// Modes that leave the source in place need to remember what was imported
+	var ledger *importLedger
+	if mode.keepsSource() {
+		ledger, err = loadImportLedger(incomingLedgerFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(files) == 0 {
+		logf(levelInfo, "No new files found in Incoming/\n")
+		return nil, nil
+	}
+
+	logf(levelInfo, "Found %d files to organize\n\n", len(files))
+
+	metas := extractMetadata(incomingDir, files, ledger)
+	if opts.Review {
+		var ok bool
+		if metas, ok = reviewPlan(metas, os.Stdin); !ok {
+			logf(levelInfo, "Review aborted, nothing was changed\n")
+			return nil, nil
+		}
+	}
+	plan := buildPlan(metas)
+
+	if opts.PlanOut != "" {
+		if err := writePlanFile(opts.PlanOut, plan, mode, incomingSourceFolder); err != nil {
+			return nil, fmt.Errorf("writing plan: %v", err)
+		}
+		logf(levelInfo, "Plan written to %s\n\n", opts.PlanOut)
+	}
+
+	// Refuse to start a run that would fill up the destination
+	if err := checkFreeSpace(plan, mode, dryRun); err != nil {
+		return nil, err
+	}
+
+	organized := executePlan(plan, mode, dryRun, ledger, incomingDir, incomingSourceFolder)
+
+	if ledger != nil && !dryRun {
+		if err := ledger.save(); err != nil {
+			logf(levelError, "Error saving import ledger: %v\n", err)
+			recordFailure(ledger.path, "save import ledger", err)
+		}
+	}
+
+	return organized, nil
+}
+
+// incomingSourceFolder returns the top-level Incoming/ folder a file came
+// from, recorded as source_folder in the manifest.
+func incomingSourceFolder(srcPath string) string {
+	srcRel, _ := filepath.Rel(incomingDir, srcPath)
+	return strings.Split(srcRel, string(os.PathSeparator))[0]
+}
+
+// fileError is a failed operation on one file.
+type fileError struct {
+	Path string // File the operation was for
+	Op   string // Operation that failed, e.g. "transfer"
+	Err  error  // Underlying error
+}
+
+// Error implements the error interface.
+func (e *fileError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Op, e.Path, e.Err)
+}
+
+// executePlan transfers the files in plan using the given mode and prints a
+// summary. If dryRun is true, only lists the planned transfers.
+// Transfers run on the worker pool; errors are reported in plan order.
+// Transferred files are recorded in ledger (if not nil) relative to srcRoot;
+// sourceFolder names the manifest source_folder for each source path.
+// Returns a slice of FileInfo for successfully transferred files.
+func executePlan(plan []planEntry, mode transferMode, dryRun bool, ledger *importLedger, srcRoot string, sourceFolder func(string) string) []FileInfo {
+	var transfers []planEntry
+	skipped, alreadyImported := 0, 0
+
+	for _, entry := range plan {
+		switch entry.Action {
+		case actionDuplicate:
+			skipped++
+			emitEvent(planEvent(eventDuplicate, entry, mode))
+		case actionImported:
+			alreadyImported++
+			emitEvent(planEvent(eventImported, entry, mode))
+		case actionTransfer:
+			transfers = append(transfers, entry)
+			if entry.Renamed {
+				emitEvent(planEvent(eventRenamed, entry, mode))
+			}
+		}
+	}
+
+	var organized []FileInfo
+
+	if dryRun {
+		for _, entry := range transfers {
+			// Display relative paths for cleaner output
+			relDest, _ := filepath.Rel(photoRoot, entry.DestPath)
+			logf(levelInfo, "  %s\n", displayPath(entry.SrcPath))
+			logf(levelInfo, "    → %s\n", relDest)
+			emitEvent(planEvent(eventPlanned, entry, mode))
+		}
+	} else {
+		parallelOrdered(len(transfers), func(i int) *fileError {
+			entry := transfers[i]
 
-		if dryRun {
-			fmt.Printf("  %s\n", relSrc)
-			fmt.Printf("    → %s\n", relDest)
-		} else {
 			// Create destination directory
-			destDir := filepath.Dir(destPath)
+			destDir := filepath.Dir(entry.DestPath)
 			if err := os.MkdirAll(destDir, 0755); err != nil {
-				fmt.Printf("Error creating directory %s: %v\n", destDir, err)
-				continue
+				return &fileError{Path: entry.SrcPath, Op: "mkdir", Err: err}
 			}
 
-			// Move file (try rename first, fall back to copy+delete for cross-device)
-			if err := os.Rename(srcPath, destPath); err != nil {
-				if err := copyFile(srcPath, destPath); err != nil {
-					fmt.Printf("Error moving %s: %v\n", srcPath, err)
-					continue
+			if err := transferFile(mode, entry.SrcPath, entry.DestPath); err != nil {
+				return &fileError{Path: entry.SrcPath, Op: "transfer", Err: err}
+			}
+			return nil
+		}, func(i int, ferr *fileError) {
+			entry := transfers[i]
+			if ferr != nil {
+				if ferr.Op == "mkdir" {
+					logf(levelError, "Error creating directory %s: %v\n", filepath.Dir(entry.DestPath), ferr.Err)
+				} else {
+					logf(levelError, "Error transferring %s (%s): %v\n", entry.SrcPath, mode, ferr.Err)
 				}
-				os.Remove(srcPath)
+				recordFailure(ferr.Path, ferr.Op, ferr.Err)
+				return
+			}
+			logf(levelInfo, "  %s\n    → %s\n", displayPath(entry.SrcPath), displayPath(entry.DestPath))
+			emitEvent(planEvent(eventMoved, entry, mode))
+
+			if ledger != nil {
+				rel, _ := filepath.Rel(srcRoot, entry.SrcPath)
+				ledger.add(rel, entry.Size, entry.ModTime, entry.DestPath)
 			}
+			metaCache.transfer(entry.SrcPath, entry.DestPath, mode.keepsSource())
 
-			// Record organized file info
-			srcInfo, _ := os.Stat(destPath)
+			// Record organized file info; every mode preserves size and mtime
 			organized = append(organized, FileInfo{
-				SrcPath:     srcPath,
-				DestPath:    destPath,
-				Size:        srcInfo.Size(),
-				ModTime:     srcInfo.ModTime(),
-				CaptureDate: getFileDate(destPath),
-				Hash:        getFileHash(destPath),
+				SrcPath:      entry.SrcPath,
+				SourceFolder: sourceFolder(entry.SrcPath),
+				DestPath:     entry.DestPath,
+				Size:         entry.Size,
+				ModTime:      entry.ModTime,
+				CaptureDate:  entry.CaptureDate,
+				DateSource:   entry.DateSource,
+				Camera:       entry.Camera,
+				GPS:          entry.GPS,
+				Video:        entry.Video,
+				Hash:         entry.Hash,
 			})
-		}
+		})
 	}
 
 	// Print summary
 	if dryRun {
-		fmt.Printf("\n[DRY RUN] Would organize %d files\n", len(files)-skipped)
+		logf(levelInfo, "\n[DRY RUN] Would organize %d files\n", len(transfers))
 		if skipped > 0 {
-			fmt.Printf("[DRY RUN] Would skip %d duplicates\n", skipped)
+			logf(levelInfo, "[DRY RUN] Would skip %d duplicates\n", skipped)
+		}
+		if alreadyImported > 0 {
+			logf(levelInfo, "[DRY RUN] Would skip %d already imported files\n", alreadyImported)
 		}
 	} else {
-		fmt.Printf("\nOrganized %d files\n", len(organized))
+		logf(levelInfo, "\nOrganized %d files\n", len(organized))
 		if skipped > 0 {
-			fmt.Printf("Skipped %d duplicates\n", skipped)
+			logf(levelInfo, "Skipped %d duplicates\n", skipped)
+		}
+		if alreadyImported > 0 {
+			logf(levelInfo, "Skipped %d already imported files\n", alreadyImported)
 		}
 	}
 
-	return organized, nil
+	return organized
+}
+
+// libraryRelPath returns path relative to the photo root with forward
+// slashes, or the absolute path for files outside the library (e.g. a
+// camera card). Used for paths stored in files under _Manifest/.
+func libraryRelPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	if rel, err := filepath.Rel(photoRoot, abs); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.ToSlash(abs)
+}
+
+// resolveLibraryPath converts a path from libraryRelPath back to a file path.
+func resolveLibraryPath(p string) string {
+	p = filepath.FromSlash(p)
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(photoRoot, p)
+}
+
+// displayPath returns path relative to the photo root for output, or the
+// path unchanged if it lies outside the library.
+func displayPath(path string) string {
+	rel, err := filepath.Rel(photoRoot, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return rel
 }
 
 // =============================================================================
 // File Operations
 // =============================================================================
 
-// copyFile copies a file from src to dst.
-// Used as fallback when os.Rename fails (cross-device moves).
+// moveFile moves src to dst.
+// Tries a rename first; if that fails (e.g. cross-device), falls back to a
+// verified copy and removes the source only once the copy is in place.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// copyFile copies a file from src to dst without ever leaving a partial file
+// at dst. The data is written to a temporary file in the destination
+// directory, fsynced, verified against the source by SHA-256 and then renamed
+// into place. The source's permissions and modification time are preserved.
 func copyFile(src, dst string) error {
 	srcFile, err := os.Open(src)
 	if err != nil {
@@ -434,119 +810,275 @@ func copyFile(src, dst string) error {
 	}
 	defer srcFile.Close()
 
-	dstFile, err := os.Create(dst)
+	srcInfo, err := srcFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	// Hash the source while copying so it is only read once
+	h := sha256.New()
+	n, err := io.Copy(tmp, io.TeeReader(srcFile, h))
+	if err != nil {
+		return err
+	}
+	if n != srcInfo.Size() {
+		return fmt.Errorf("short copy: wrote %d of %d bytes", n, srcInfo.Size())
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	// Re-read what actually landed on disk and compare
+	dstHash, err := hashFile(tmpPath)
+	if err != nil {
+		return err
+	}
+	if srcHash := fmt.Sprintf("%x", h.Sum(nil)); dstHash != srcHash {
+		return fmt.Errorf("verification failed: hash mismatch for %s", dst)
+	}
+
+	if err := os.Chmod(tmpPath, srcInfo.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmpPath, srcInfo.ModTime(), srcInfo.ModTime()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, dst); err != nil {
+		return err
+	}
+	committed = true
+
+	syncDir(filepath.Dir(dst))
+	return nil
+}
+
+// writeFileAtomic writes a file through a temporary file in the same
+// directory that is fsynced and renamed over path, so a crash or full disk
+// never leaves a truncated file behind.
+func writeFileAtomic(path string, perm os.FileMode, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	err = write(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
 	if err != nil {
+		os.Remove(tmpPath)
 		return err
 	}
-	defer dstFile.Close()
 
-	_, err = io.Copy(dstFile, srcFile)
-	return err
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// syncDir fsyncs a directory so a preceding rename is durable.
+// Best effort: some platforms (Windows) cannot sync directories.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
 }
 
 // =============================================================================
 // Manifest Management
 // =============================================================================
 
-// updateManifest adds newly organized files to the manifest CSV.
-// Creates the manifest file if it doesn't exist.
-// Preserves existing entries and appends new ones.
-func updateManifest(organized []FileInfo) error {
-	// Ensure manifest directory exists
-	if err := os.MkdirAll(manifestDir, 0755); err != nil {
-		return err
+// manifestTable is the content of a manifest CSV: its schema version, its
+// columns in file order, and each row keyed by column name. Columns the
+// organizer doesn't know are kept as they are.
+type manifestTable struct {
+	schema  int                 // Schema version (1 for manifests without a marker)
+	headers []string            // Column names, in file order
+	rows    []map[string]string // Column name -> value
+}
+
+// manifestSchemaMarker starts the first line of a manifest CSV, followed by
+// the schema version.
+const manifestSchemaMarker = "# photo-organizer manifest schema "
+
+// readManifest reads the manifest CSV at path, mapping every row by column
+// name. A missing manifest yields an empty table; a manifest that cannot be
+// parsed is an error.
+func readManifest(path string) (*manifestTable, error) {
+	t := &manifestTable{schema: manifestSchemaVersion}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Manifests written before schema versioning have no marker
+	content := strings.TrimPrefix(string(data), "\ufeff")
+	t.schema = 1
+	first, rest, _ := strings.Cut(content, "\n")
+	if marker, ok := schemaMarkerField(first); ok {
+		v, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(marker, manifestSchemaMarker)))
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse %s: bad schema marker %q", path, first)
+		}
+		t.schema, content = v, rest
+	}
+
+	reader := csv.NewReader(strings.NewReader(content))
+	reader.FieldsPerRecord = -1 // Spreadsheets may drop trailing empty cells
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %v", path, err)
+	}
+	if len(records) == 0 {
+		return t, nil
 	}
 
-	// Read existing entries from manifest
-	existing := make(map[string][]string)
-	var headers []string
+	t.headers = records[0]
+	for _, rec := range records[1:] {
+		row := make(map[string]string, len(t.headers))
+		for i, h := range t.headers {
+			if i < len(rec) {
+				row[h] = rec[i]
+			}
+		}
+		t.rows = append(t.rows, row)
+	}
+	return t, nil
+}
 
-	if f, err := os.Open(manifestFile); err == nil {
-		reader := csv.NewReader(f)
-		records, _ := reader.ReadAll()
-		f.Close()
+// schemaMarkerField returns the schema marker from the first line of a
+// manifest, and whether the line is one. Spreadsheets save the marker as
+// the first cell of a CSV row, so it may be quoted and followed by empty
+// cells ("# photo-organizer manifest schema 6",,,); only that cell counts.
+func schemaMarkerField(line string) (string, bool) {
+	line = strings.TrimRight(line, "\r")
+	field := line
+	if rec, err := csv.NewReader(strings.NewReader(line)).Read(); err == nil && len(rec) > 0 {
+		field = rec[0]
+	} else if before, _, found := strings.Cut(line, ","); found {
+		field = before
+	}
+	field = strings.TrimSpace(strings.Trim(field, `"`))
+	return field, strings.HasPrefix(field, manifestSchemaMarker)
+}
 
-		if len(records) > 0 {
-			headers = records[0]
-			for _, row := range records[1:] {
-				if len(row) > 1 {
-					existing[row[1]] = row // Key by relative_path
-				}
+// upgrade brings the table to the current schema: known columns it lacks
+// are appended (empty for existing rows). Existing columns keep their
+// order, and unknown ones are kept. Returns the names of the columns added
+// by newer schema versions; columns a user deleted are just restored.
+func (t *manifestTable) upgrade() []string {
+	have := make(map[string]bool)
+	for _, h := range t.headers {
+		have[h] = true
+	}
+
+	var added []string
+	for _, c := range manifestColumns {
+		if !have[c.name] {
+			t.headers = append(t.headers, c.name)
+			if len(t.rows) > 0 && c.since > t.schema {
+				added = append(added, c.name)
 			}
 		}
 	}
+	t.schema = manifestSchemaVersion
+	return added
+}
 
-	// Define headers if manifest is new
-	if len(headers) == 0 {
-		headers = []string{
-			"filename",        // Base filename
-			"relative_path",   // Path relative to photo root
-			"source_folder",   // Original folder in Incoming/
-			"file_size_bytes", // Size in bytes
-			"file_size_mb",    // Size in megabytes
-			"file_modified",   // File modification timestamp
-			"capture_date",    // EXIF/parsed capture date
-			"camera_make",     // Camera manufacturer (if available)
-			"camera_model",    // Camera model (if available)
-			"file_hash",       // MD5 hash of first 64KB
-			"extension",       // File extension
-			"organized_date",  // When file was organized
-		}
-	}
-
-	// Add new entries
-	newCount := 0
-	for _, fi := range organized {
-		relPath, _ := filepath.Rel(photoRoot, fi.DestPath)
-		if _, exists := existing[relPath]; exists {
-			continue // Skip if already in manifest
-		}
-
-		// Determine source folder
-		srcRel, _ := filepath.Rel(incomingDir, fi.SrcPath)
-		sourceFolder := strings.Split(srcRel, string(os.PathSeparator))[0]
-
-		row := []string{
-			filepath.Base(fi.DestPath),
-			relPath,
-			sourceFolder,
-			fmt.Sprintf("%d", fi.Size),
-			fmt.Sprintf("%.2f", float64(fi.Size)/(1024*1024)),
-			fi.ModTime.Format("2006-01-02 15:04:05"),
-			fi.CaptureDate.Format("2006:01:02 15:04:05"),
-			"", "", // camera make/model (not extracted in Go version)
-			fi.Hash,
-			strings.ToLower(filepath.Ext(fi.DestPath)),
-			time.Now().Format("2006-01-02 15:04:05"),
-		}
-		existing[relPath] = row
-		newCount++
-	}
-
-	// Write updated manifest
-	f, err := os.Create(manifestFile)
-	if err != nil {
+// write writes the table as CSV, with the schema marker first. Values are
+// placed by column name, so rows always line up with the headers.
+func (t *manifestTable) write(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "%s%d\n", manifestSchemaMarker, t.schema); err != nil {
 		return err
 	}
-	defer f.Close()
+	return t.writeRows(w)
+}
+
+// writeRows writes the table as plain CSV: the header row, then the rows.
+func (t *manifestTable) writeRows(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write(t.headers)
+	values := make([]string, len(t.headers))
+	for _, row := range t.rows {
+		for i, h := range t.headers {
+			values[i] = row[h]
+		}
+		writer.Write(values)
+	}
+	writer.Flush()
+	return writer.Error()
+}
 
-	writer := csv.NewWriter(f)
-	writer.Write(headers)
+// backupManifest copies the manifest at path into _Manifest/backups/ with a
+// timestamped name and prunes the oldest backups of it beyond
+// manifestBackups. Does nothing if there is no manifest yet or backups are
+// disabled.
+func backupManifest(path string) error {
+	if manifestBackups <= 0 {
+		return nil
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
 
-	// Sort entries by relative path for consistent output
-	var paths []string
-	for p := range existing {
-		paths = append(paths, p)
+	if err := os.MkdirAll(backupsDir, 0755); err != nil {
+		return err
 	}
-	sort.Strings(paths)
 
-	for _, p := range paths {
-		writer.Write(existing[p])
+	// Timestamps sort lexically, so name order is age order
+	ext := filepath.Ext(path)
+	prefix := strings.TrimSuffix(filepath.Base(path), ext) + "-"
+	name := prefix + time.Now().Format("20060102-150405.000") + ext
+	if err := copyFile(path, filepath.Join(backupsDir, name)); err != nil {
+		return err
 	}
-	writer.Flush()
 
-	if newCount > 0 {
-		fmt.Printf("Added %d entries to manifest\n", newCount)
+	entries, err := os.ReadDir(backupsDir)
+	if err != nil {
+		return err
+	}
+	var backups []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), prefix) && strings.HasSuffix(e.Name(), ext) {
+			backups = append(backups, e.Name())
+		}
+	}
+	sort.Strings(backups)
+
+	for len(backups) > manifestBackups {
+		os.Remove(filepath.Join(backupsDir, backups[0]))
+		backups = backups[1:]
 	}
 
 	return nil
@@ -556,39 +1088,133 @@ func updateManifest(organized []FileInfo) error {
 // Cleanup
 // =============================================================================
 
-// cleanupEmptyFolders removes empty directories from Incoming.
-// Only removes directories that contain no visible (non-hidden) files.
-func cleanupEmptyFolders() {
-	removed := 0
+// isJunkFile returns true for OS-generated clutter that is safe to delete:
+// macOS .DS_Store and AppleDouble ._* files, and Windows Thumbs.db.
+func isJunkFile(name string) bool {
+	return name == ".DS_Store" || name == "Thumbs.db" || strings.HasPrefix(name, "._")
+}
 
-	filepath.Walk(incomingDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil || !info.IsDir() || path == incomingDir {
+// cleanupEmptyFolders removes emptied directories from Incoming.
+// A directory is removed only if it has no subdirectories left and no visible
+// files other than junk. Known junk is deleted; any other leftover content
+// (hidden sidecars, .trashed-* files, hidden folders) is moved to
+// _Manifest/trash/<run-id>/ rather than deleted. Directories are processed
+// bottom-up so parents emptied by their children are removed as well.
+// Hidden directories and skipFolders are never touched.
+func cleanupEmptyFolders() {
+	// Collect directories the organizer scans; deepest first
+	var dirs []string
+	filepath.WalkDir(incomingDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
 			return nil
 		}
+		if path != incomingDir && (strings.HasPrefix(d.Name(), ".") || skipFolders[d.Name()]) {
+			return filepath.SkipDir
+		}
+		if path != incomingDir {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	sort.Slice(dirs, func(i, j int) bool {
+		di := strings.Count(dirs[i], string(os.PathSeparator))
+		dj := strings.Count(dirs[j], string(os.PathSeparator))
+		if di != dj {
+			return di > dj
+		}
+		return dirs[i] > dirs[j]
+	})
 
-		entries, _ := os.ReadDir(path)
+	trashRoot := filepath.Join(trashDir, runID)
+	removedDirs, removedJunk, trashed := 0, 0, 0
 
-		// Count visible (non-hidden) files
-		visible := 0
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		// Decide whether the folder is empty enough to remove
+		var junk, leftovers []os.DirEntry
+		keep := false
 		for _, e := range entries {
-			if !strings.HasPrefix(e.Name(), ".") {
-				visible++
+			name := e.Name()
+			switch {
+			case isJunkFile(name) && !e.IsDir():
+				junk = append(junk, e)
+			case skipFolders[name]:
+				keep = true // e.g. Syncthing's .stfolder marker
+			case strings.HasPrefix(name, "."):
+				leftovers = append(leftovers, e)
+			default:
+				keep = true // Visible file or remaining subfolder
 			}
 		}
+		if keep {
+			continue
+		}
 
-		// Remove if empty
-		if visible == 0 {
-			os.RemoveAll(path)
-			removed++
+		relDir, _ := filepath.Rel(incomingDir, dir)
+
+		for _, e := range junk {
+			if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+				logf(levelError, "  Error removing %s: %v\n", filepath.Join(relDir, e.Name()), err)
+				recordFailure(filepath.Join(dir, e.Name()), "cleanup", err)
+				keep = true
+				continue
+			}
+			logf(levelInfo, "  Removed junk: %s\n", filepath.Join(relDir, e.Name()))
+			removedJunk++
 		}
-		return nil
-	})
 
-	if removed > 0 {
-		fmt.Printf("Cleaned up %d empty folders\n", removed)
+		for _, e := range leftovers {
+			src := filepath.Join(dir, e.Name())
+			dst := filepath.Join(trashRoot, relDir, e.Name())
+			if err := moveToTrash(src, dst, e.IsDir()); err != nil {
+				logf(levelError, "  Error moving %s to trash: %v\n", filepath.Join(relDir, e.Name()), err)
+				recordFailure(src, "cleanup", err)
+				keep = true
+				continue
+			}
+			relDst, _ := filepath.Rel(photoRoot, dst)
+			logf(levelInfo, "  Moved to trash: %s → %s\n", filepath.Join(relDir, e.Name()), relDst)
+			trashed++
+		}
+
+		if keep {
+			continue
+		}
+		if err := os.Remove(dir); err != nil {
+			logf(levelError, "  Error removing folder %s: %v\n", relDir, err)
+			recordFailure(dir, "cleanup", err)
+			continue
+		}
+		logf(levelInfo, "  Removed empty folder: %s\n", relDir)
+		removedDirs++
+	}
+
+	if removedDirs > 0 || removedJunk > 0 || trashed > 0 {
+		logf(levelInfo, "Cleaned up %d empty folders (%d junk files deleted, %d items moved to trash)\n",
+			removedDirs, removedJunk, trashed)
 	}
 }
 
+// moveToTrash moves a leftover file or folder from Incoming into the trash.
+// Folders can only be moved within the same filesystem; files fall back to a
+// verified copy.
+func moveToTrash(src, dst string, isDir bool) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("%s already exists", dst)
+	}
+	if isDir {
+		return os.Rename(src, dst)
+	}
+	return moveFile(src, dst)
+}
+
 // =============================================================================
 // Skill Installation
 // =============================================================================
@@ -618,6 +1244,7 @@ When the user invokes this skill (e.g., ` + "`/organize-photos`" + `), help them
    - Execute: ` + "`./photo-organizer -x`" + `
    - Execute + manifest: ` + "`./photo-organizer -x -m`" + `
    - Custom root: ` + "`./photo-organizer --root /path/to/photos -x`" + `
+   - Machine-readable: add ` + "`--output json`" + ` for one JSON event per file and a final summary line on stdout
 
 3. **Explain the output**: Help them understand what happened, including:
    - How many files were found
@@ -735,6 +1362,7 @@ func installSkill(targetDir string) error {
 	}
 
 	fmt.Printf("✓ Installed Claude Code skill to %s\n", skillFile)
+	emitEvent(outputEvent{Event: eventInstalled, Path: skillFile})
 	fmt.Println("\nYou can now use the skill in Claude Code by running:")
 	fmt.Println("  /organize-photos")
 	return nil
@@ -767,6 +1395,7 @@ func initPhotoLibrary(targetDir string) error {
 		// Check if directory already exists
 		if _, err := os.Stat(dir.path); err == nil {
 			fmt.Printf("⊘ %s (already exists)\n", filepath.Base(dir.path))
+			emitEvent(outputEvent{Event: eventExists, Path: dir.path})
 			skipped++
 			continue
 		}
@@ -777,6 +1406,7 @@ func initPhotoLibrary(targetDir string) error {
 		}
 
 		fmt.Printf("✓ %s/ - %s\n", filepath.Base(dir.path), dir.desc)
+		emitEvent(outputEvent{Event: eventCreated, Path: dir.path})
 		created++
 	}
 
@@ -801,7 +1431,64 @@ func initPhotoLibrary(targetDir string) error {
 // Main Entry Point
 // =============================================================================
 
+// setLibraryPaths sets the global path variables for the library at root.
+// An empty root means the current directory.
+func setLibraryPaths(root string) error {
+	if root == "" {
+		var err error
+		root, err = os.Getwd()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Absolute, so paths stored relative to the root stay relative however
+	// the root was given
+	photoRoot = absLibraryRoot(root)
+	incomingDir = filepath.Join(photoRoot, "Incoming")
+	originalsDir = filepath.Join(photoRoot, "Originals")
+	manifestDir = filepath.Join(photoRoot, "_Manifest")
+	manifestFile = filepath.Join(manifestDir, "photo_manifest.csv")
+	catalogFile = filepath.Join(manifestDir, "catalog.db")
+	lockFile = filepath.Join(manifestDir, "organizer.lock")
+	backupsDir = filepath.Join(manifestDir, "backups")
+	trashDir = filepath.Join(manifestDir, "trash")
+	cacheFile = filepath.Join(manifestDir, "metadata_cache.csv")
+	logsDir = filepath.Join(manifestDir, "logs")
+	geonamesDir = filepath.Join(manifestDir, "geonames")
+	layoutFile = filepath.Join(manifestDir, "layout.txt")
+	importsDir = filepath.Join(manifestDir, "imports")
+	incomingLedgerFile = filepath.Join(importsDir, "Incoming.csv")
+	return nil
+}
+
 func main() {
+	// Dispatch subcommands; everything else is the organize run
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "import":
+			exitRun(runImport(os.Args[2:]))
+		case "verify-source":
+			os.Exit(runVerifySource(os.Args[2:]))
+		case "cache":
+			os.Exit(runCache(os.Args[2:]))
+		case "apply":
+			exitRun(runApply(os.Args[2:]))
+		case "manifest":
+			os.Exit(runManifest(os.Args[2:]))
+		case "fsck":
+			os.Exit(runFsck(os.Args[2:]))
+		case "reindex":
+			os.Exit(runReindex(os.Args[2:]))
+		case "query":
+			os.Exit(runQuery(os.Args[2:]))
+		case "geo":
+			os.Exit(runGeo(os.Args[2:]))
+		case "geotag":
+			os.Exit(runGeotag(os.Args[2:]))
+		}
+	}
+
 	// Define command-line flags
 	execute := flag.Bool("execute", false, "Actually move files (default is dry-run)")
 	executeShort := flag.Bool("x", false, "Actually move files (short for --execute)")
@@ -810,12 +1497,36 @@ func main() {
 	rootDir := flag.String("root", "", "Photo library root directory (default: current directory)")
 	installSkillFlag := flag.Bool("install-skill", false, "Install Claude Code skill to .claude/skills/")
 	initFlag := flag.Bool("init", false, "Initialize photo library directory structure")
+	modeFlag := flag.String("mode", "move", "Transfer mode: move, copy, hardlink or reflink")
+	flag.IntVar(&manifestBackups, "manifest-backups", manifestBackups, "Number of manifest backups to keep in _Manifest/backups/ (0 disables)")
+	reserveFlag := flag.String("reserve", "1GB", "Free space to keep on the destination filesystem (e.g. 500MB, 10GB)")
+	flag.IntVar(&jobs, "jobs", jobs, "Number of files to process in parallel")
+	noCache := flag.Bool("no-cache", false, "Don't read or update the metadata cache")
+	reviewFlag := flag.Bool("review", false, "Review the plan interactively (exclude files, fix dates, label events) before running")
+	planOutFlag := flag.String("plan-out", "", "Write every planned decision to this JSON file (see the apply command)")
+	waitFlag := flag.Duration("wait", 0, "Wait up to this long if another run has the library locked (e.g. 10m)")
+	versionFlag := flag.Bool("version", false, "Print the version and exit")
+	logOpts := addLogFlags(flag.CommandLine)
+	layoutFlag := flag.String("layout", "", layoutFlagUsage)
+	outputFlag := flag.String("output", "text", "Output format: text, or json for NDJSON events on stdout (text goes to stderr)")
 
 	// Custom usage message
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Photo Organizer - Organize photos by capture date\n\n")
 		fmt.Fprintf(os.Stderr, "Usage:\n")
-		fmt.Fprintf(os.Stderr, "  %s [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s <command> [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Commands:\n")
+		fmt.Fprintf(os.Stderr, "  import         Copy from a camera card or folder into Originals (read-only)\n")
+		fmt.Fprintf(os.Stderr, "  verify-source  Check every file on a card is in the library before formatting\n")
+		fmt.Fprintf(os.Stderr, "  apply          Execute a plan written with --plan-out, exactly as reviewed\n")
+		fmt.Fprintf(os.Stderr, "  manifest       Migrate the manifest to a SQLite catalog, or export it as CSV\n")
+		fmt.Fprintf(os.Stderr, "  fsck           Check Originals against the manifest (--fix to update it)\n")
+		fmt.Fprintf(os.Stderr, "  reindex        Rebuild the manifest from the files in Originals\n")
+		fmt.Fprintf(os.Stderr, "  query          List manifest entries by date, type, source, camera or size\n")
+		fmt.Fprintf(os.Stderr, "  geo export     Write photo locations as GeoJSON or GPX\n")
+		fmt.Fprintf(os.Stderr, "  geotag         Add positions from a GPX track log (manifest, XMP sidecars)\n")
+		fmt.Fprintf(os.Stderr, "  cache prune    Drop stale entries from the metadata cache\n\n")
 		fmt.Fprintf(os.Stderr, "Options:\n")
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nExamples:\n")
@@ -823,12 +1534,41 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  %s -x               # Execute file moves\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -x -m            # Execute and update manifest\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s --root /path     # Use custom root directory\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -x --mode copy   # Copy, leaving Incoming untouched\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --plan-out p.json && %s apply p.json  # Review, then execute exactly that\n", os.Args[0], os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -x --review      # Review and adjust interactively, then execute\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -x --output json # One JSON event per file, then a summary\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s --init           # Initialize photo library structure\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s --install-skill  # Install Claude Code skill\n", os.Args[0])
 	}
 
 	flag.Parse()
 
+	if *versionFlag {
+		fmt.Println("photo-organizer", version)
+		os.Exit(exitOK)
+	}
+	if err := setOutputFormat(*outputFlag); err != nil {
+		fmt.Fprintln(os.Stderr, "Error: --output:", err)
+		os.Exit(exitUsage)
+	}
+	if err := logOpts.apply(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitUsage)
+	}
+
+	// Combine short and long flags
+	doExecute := *execute || *executeShort
+	doUpdateManifest := *updateManifestFlag || *updateManifestShort
+	dryRun := !doExecute
+
+	// fatal reports an error that ends the run, in text and in the summary
+	fatal := func(command, msg string, err error) {
+		logf(levelError, "%s %v\n", msg, err)
+		emitSummary(command, dryRun, exitFatal, err)
+		exitRun(exitFatal)
+	}
+
 	// Handle library initialization
 	if *initFlag {
 		targetDir := *rootDir
@@ -836,15 +1576,14 @@ func main() {
 			var err error
 			targetDir, err = os.Getwd()
 			if err != nil {
-				fmt.Println("Error getting current directory:", err)
-				os.Exit(1)
+				fatal("init", "Error getting current directory:", err)
 			}
 		}
 		if err := initPhotoLibrary(targetDir); err != nil {
-			fmt.Printf("Error initializing library: %v\n", err)
-			os.Exit(1)
+			fatal("init", "Error initializing library:", err)
 		}
-		os.Exit(0)
+		emitSummary("init", false, exitOK, nil)
+		os.Exit(exitOK)
 	}
 
 	// Handle skill installation
@@ -854,73 +1593,103 @@ func main() {
 			var err error
 			targetDir, err = os.Getwd()
 			if err != nil {
-				fmt.Println("Error getting current directory:", err)
-				os.Exit(1)
+				fatal("install-skill", "Error getting current directory:", err)
 			}
 		}
 		if err := installSkill(targetDir); err != nil {
-			fmt.Printf("Error installing skill: %v\n", err)
-			os.Exit(1)
+			fatal("install-skill", "Error installing skill:", err)
 		}
-		os.Exit(0)
+		emitSummary("install-skill", false, exitOK, nil)
+		os.Exit(exitOK)
 	}
 
-	// Combine short and long flags
-	doExecute := *execute || *executeShort
-	doUpdateManifest := *updateManifestFlag || *updateManifestShort
-	dryRun := !doExecute
+	mode, err := parseTransferMode(*modeFlag)
+	if err != nil {
+		fatal("organize", "Error:", err)
+	}
+	spaceReserve, err = parseSize(*reserveFlag)
+	if err != nil {
+		fatal("organize", "Error: --reserve:", err)
+	}
 
 	// Set paths based on root directory
-	if *rootDir != "" {
-		photoRoot = *rootDir
-	} else {
-		var err error
-		photoRoot, err = os.Getwd()
-		if err != nil {
-			fmt.Println("Error getting current directory:", err)
-			os.Exit(1)
-		}
+	if err := setLibraryPaths(*rootDir); err != nil {
+		fatal("organize", "Error getting current directory:", err)
 	}
+	if err := loadFolderLayout(*layoutFlag); err != nil {
+		fatal("organize", "Error:", err)
+	}
+	if err := openRunLog("organize"); err != nil {
+		logf(levelWarn, "Warning: could not open run log: %v\n", err)
+	}
+	checkPlaceLayout()
 
-	incomingDir = filepath.Join(photoRoot, "Incoming")
-	originalsDir = filepath.Join(photoRoot, "Originals")
-	manifestDir = filepath.Join(photoRoot, "_Manifest")
-	manifestFile = filepath.Join(manifestDir, "photo_manifest.csv")
+	if !*noCache {
+		metaCache = loadMetadataCache(cacheFile)
+	}
 
 	// Validate that Incoming directory exists
 	if _, err := os.Stat(incomingDir); os.IsNotExist(err) {
-		fmt.Printf("Error: Incoming directory not found at %s\n", incomingDir)
-		os.Exit(1)
+		fatal("organize", "Error:", fmt.Errorf("Incoming directory not found at %s", incomingDir))
 	}
 
 	// Print banner
-	fmt.Println(strings.Repeat("=", 50))
-	fmt.Println("Photo Organizer")
-	fmt.Println(strings.Repeat("=", 50))
-	fmt.Printf("Incoming:  %s\n", incomingDir)
-	fmt.Printf("Originals: %s\n", originalsDir)
-	fmt.Println()
+	logf(levelInfo, "%s\n", strings.Repeat("=", 50))
+	logf(levelInfo, "Photo Organizer\n")
+	logf(levelInfo, "%s\n", strings.Repeat("=", 50))
+	logf(levelInfo, "Incoming:  %s\n", incomingDir)
+	logf(levelInfo, "Originals: %s\n", originalsDir)
+	logf(levelInfo, "Mode:      %s\n", mode)
+	logf(levelInfo, "\n")
 
 	if dryRun {
-		fmt.Println("[DRY RUN MODE - use --execute or -x to actually move files]\n")
+		logf(levelInfo, "[DRY RUN MODE - use --execute or -x to actually move files]\n")
+		logf(levelInfo, "\n")
+	}
+
+	// Only one run may move files or rewrite the manifest at a time
+	var lock *libraryLock
+	if !dryRun {
+		lock, err = acquireLibraryLock(*waitFlag)
+		if err != nil {
+			fatal("organize", "Error:", err)
+		}
+		if err := saveFolderLayout(); err != nil {
+			lock.release()
+			fatal("organize", "Error saving folder layout:", err)
+		}
 	}
 
 	// Run organization
-	organized, err := organizeFiles(dryRun)
+	organized, err := organizeFiles(organizeOptions{
+		DryRun:  dryRun,
+		Mode:    mode,
+		PlanOut: *planOutFlag,
+		Review:  *reviewFlag,
+	})
+	saveMetadataCache()
 	if err != nil {
-		fmt.Println("Error organizing files:", err)
-		os.Exit(1)
+		lock.release()
+		fatal("organize", "Error organizing files:", err)
 	}
 
 	// Post-processing (only when actually executing)
 	if !dryRun {
 		if len(organized) > 0 && doUpdateManifest {
 			if err := updateManifest(organized); err != nil {
-				fmt.Println("Error updating manifest:", err)
+				logf(levelError, "Error updating manifest: %v\n", err)
+				recordFailure(manifestFile, "update manifest", err)
 			}
 		}
-		cleanupEmptyFolders()
+		// Sources are still in place for non-move modes, nothing to clean
+		if !mode.keepsSource() {
+			cleanupEmptyFolders()
+		}
 	}
 
-	fmt.Println("\nDone!")
+	lock.release()
+	code := finishRun()
+	logf(levelInfo, "\nDone!\n")
+	emitSummary("organize", dryRun, code, nil)
+	exitRun(code)
 }
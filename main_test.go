@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// chdirTemp changes to a fresh temporary directory for the rest of the test.
+func chdirTemp(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return dir
+}
+
+func TestLibraryPathsRelativeRoot(t *testing.T) {
+	dir := chdirTemp(t)
+	if err := setLibraryPaths("lib"); err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "lib"); photoRoot != want {
+		t.Fatalf("photoRoot = %q, want %q", photoRoot, want)
+	}
+
+	src := filepath.Join("lib", "Incoming", "Phone", "IMG_0001.JPG")
+	abs := filepath.Join(dir, src)
+	for _, path := range []string{src, abs, filepath.Join(incomingDir, "Phone", "IMG_0001.JPG")} {
+		if got := libraryRelPath(path); got != "Incoming/Phone/IMG_0001.JPG" {
+			t.Errorf("libraryRelPath(%q) = %q, want Incoming/Phone/IMG_0001.JPG", path, got)
+		}
+	}
+	if got := resolveLibraryPath("Incoming/Phone/IMG_0001.JPG"); got != abs {
+		t.Errorf("resolveLibraryPath = %q, want %q", got, abs)
+	}
+	if got := displayPath(abs); got != filepath.Join("Incoming", "Phone", "IMG_0001.JPG") {
+		t.Errorf("displayPath = %q", got)
+	}
+
+	// Paths outside the library stay absolute
+	outside := filepath.Join(dir, "card", "DCIM", "IMG_0002.JPG")
+	if got := libraryRelPath(filepath.Join("card", "DCIM", "IMG_0002.JPG")); got != filepath.ToSlash(outside) {
+		t.Errorf("libraryRelPath outside the library = %q, want %q", got, outside)
+	}
+
+	// Cache keys are relative to the library too
+	writeTestFile(t, "Incoming/Phone/IMG_0001.JPG", "data")
+	info, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metaCache = loadMetadataCache(cacheFile)
+	defer func() { metaCache = nil }()
+	metaCache.update(src, info, func(e *cacheEntry) { e.Hash = "abc" })
+	if _, ok := metaCache.entries["Incoming/Phone/IMG_0001.JPG"]; !ok {
+		t.Errorf("cache keys = %v, want Incoming/Phone/IMG_0001.JPG", metaCache.entries)
+	}
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// Manifest Backends
+// =============================================================================
+//
+// The manifest records every organized file. It is stored either in the CSV
+// file _Manifest/photo_manifest.csv, or, once migrated with
+// "manifest migrate", in the SQLite catalog _Manifest/catalog.db. The CSV
+// format remains available as an export of the catalog.
+
+// Manifest CSV timestamp layouts.
+const (
+	manifestTimeLayout    = "2006-01-02 15:04:05" // file_modified, organized_date
+	manifestCaptureLayout = "2006:01:02 15:04:05" // capture_date (EXIF style)
+)
+
+// manifestSchemaVersion is the current manifest CSV schema. Bump it, and
+// add the columns below with the new version, whenever columns are added.
+const manifestSchemaVersion = 6
+
+// manifestColumns are the columns of the manifest CSV, in the order a new
+// manifest uses, with the schema version that introduced each. Older
+// manifests get the missing columns appended when next updated.
+var manifestColumns = []struct {
+	name  string
+	since int
+}{
+	{"filename", 1},        // Base filename
+	{"relative_path", 1},   // Path relative to photo root
+	{"source_folder", 1},   // Original folder in Incoming/
+	{"file_size_bytes", 1}, // Size in bytes
+	{"file_size_mb", 1},    // Size in megabytes
+	{"file_modified", 1},   // File modification timestamp
+	{"capture_date", 1},    // EXIF/parsed capture date
+	{"camera_make", 1},     // Camera manufacturer (if available)
+	{"camera_model", 1},    // Camera model (if available)
+	{"file_hash", 1},       // MD5 hash of first 64KB
+	{"extension", 1},       // File extension
+	{"organized_date", 1},  // When file was organized
+	{"date_source", 2},     // Where capture_date came from (exif, filename, ...)
+	{"source_path", 2},     // Where the file was organized from
+	{"camera_serial", 3},   // Camera body serial number
+	{"lens_model", 3},      // Lens model
+	{"focal_length_mm", 3}, // Focal length in millimetres
+	{"f_number", 3},        // Aperture
+	{"shutter_speed", 3},   // Exposure time, e.g. 1/250
+	{"iso", 3},             // ISO speed
+	{"orientation", 3},     // EXIF orientation (1-8)
+	{"width_px", 3},        // Image width in pixels
+	{"height_px", 3},       // Image height in pixels
+	{"gps_latitude", 4},    // Decimal degrees, north positive
+	{"gps_longitude", 4},   // Decimal degrees, east positive
+	{"gps_altitude_m", 4},  // Metres above sea level
+	{"place_city", 5},      // Nearest city to the GPS position
+	{"place_region", 5},    // Its region, e.g. state or district
+	{"place_country", 5},   // Its country
+	{"duration_s", 6},      // Video duration in seconds
+	{"frame_rate", 6},      // Video frames per second
+	{"video_codec", 6},     // Video codec FourCC (avc1, hvc1, ...)
+}
+
+// manifestRecord is one organized file in the manifest.
+type manifestRecord struct {
+	Filename      string       // Base filename
+	RelativePath  string       // Path relative to the photo root
+	SourceFolder  string       // Top-level Incoming/ folder, or import label
+	Size          int64        // Size in bytes
+	Modified      time.Time    // File modification time
+	CaptureDate   time.Time    // Capture date
+	DateSource    string       // Where CaptureDate came from ("" if unknown)
+	Camera        cameraInfo   // Camera, lens and exposure (if available)
+	GPS           *gpsPosition // Where it was taken (nil if unknown)
+	Place         placeName    // Where it was taken, by name (if known)
+	Video         videoInfo    // Duration, frame rate and codec (videos)
+	Hash          string       // MD5 of the first 64KB
+	Extension     string       // Lower-case file extension
+	OrganizedDate time.Time    // When the file was organized
+	SourcePath    string       // Where it was organized from ("" if unknown)
+}
+
+// newManifestRecord builds the manifest record for a file organized now.
+func newManifestRecord(fi FileInfo) manifestRecord {
+	relPath, _ := filepath.Rel(photoRoot, fi.DestPath)
+	return manifestRecord{
+		Filename:      filepath.Base(fi.DestPath),
+		RelativePath:  relPath,
+		SourceFolder:  fi.SourceFolder,
+		Size:          fi.Size,
+		Modified:      fi.ModTime,
+		CaptureDate:   fi.CaptureDate,
+		DateSource:    fi.DateSource,
+		Camera:        fi.Camera,
+		GPS:           fi.GPS,
+		Place:         reverseGeocode(fi.GPS),
+		Video:         fi.Video,
+		Hash:          fi.Hash,
+		Extension:     strings.ToLower(filepath.Ext(fi.DestPath)),
+		OrganizedDate: time.Now(),
+		SourcePath:    libraryRelPath(fi.SrcPath),
+	}
+}
+
+// fields returns the record's manifest CSV values by column name.
+func (r manifestRecord) fields() map[string]string {
+	f := map[string]string{
+		"filename":        r.Filename,
+		"relative_path":   r.RelativePath,
+		"source_folder":   r.SourceFolder,
+		"file_size_bytes": strconv.FormatInt(r.Size, 10),
+		"file_size_mb":    fmt.Sprintf("%.2f", float64(r.Size)/(1024*1024)),
+		"file_modified":   r.Modified.Format(manifestTimeLayout),
+		"capture_date":    r.CaptureDate.Format(manifestCaptureLayout),
+		"file_hash":       r.Hash,
+		"extension":       r.Extension,
+		"organized_date":  r.OrganizedDate.Format(manifestTimeLayout),
+		"date_source":     r.DateSource,
+		"source_path":     r.SourcePath,
+	}
+	for i, v := range r.Camera.values() {
+		f[cameraColumns[i]] = v
+	}
+	for i, v := range gpsValues(r.GPS) {
+		f[gpsColumns[i]] = v
+	}
+	for i, v := range r.Place.values() {
+		f[placeColumns[i]] = v
+	}
+	for i, v := range r.Video.values() {
+		f[videoColumns[i]] = v
+	}
+	return f
+}
+
+// manifestRecordFromFields parses a manifest CSV row mapped by column name.
+// Missing and unparsable values are left empty.
+func manifestRecordFromFields(f map[string]string) manifestRecord {
+	r := manifestRecord{
+		Filename:     f["filename"],
+		RelativePath: f["relative_path"],
+		SourceFolder: f["source_folder"],
+		Hash:         f["file_hash"],
+		Extension:    f["extension"],
+		DateSource:   f["date_source"],
+		SourcePath:   f["source_path"],
+	}
+	var camera []string
+	for _, col := range cameraColumns {
+		camera = append(camera, f[col])
+	}
+	r.Camera = parseCameraInfo(camera)
+	var gps []string
+	for _, col := range gpsColumns {
+		gps = append(gps, f[col])
+	}
+	r.GPS = parseGPSPosition(gps)
+	var place []string
+	for _, col := range placeColumns {
+		place = append(place, f[col])
+	}
+	r.Place = parsePlaceName(place)
+	var video []string
+	for _, col := range videoColumns {
+		video = append(video, f[col])
+	}
+	r.Video = parseVideoInfo(video)
+	r.Size, _ = strconv.ParseInt(f["file_size_bytes"], 10, 64)
+	r.Modified, _ = time.ParseInLocation(manifestTimeLayout, f["file_modified"], time.Local)
+	r.CaptureDate, _ = time.ParseInLocation(manifestCaptureLayout, f["capture_date"], time.Local)
+	r.OrganizedDate, _ = time.ParseInLocation(manifestTimeLayout, f["organized_date"], time.Local)
+	return r
+}
+
+// manifestBackend stores the manifest.
+type manifestBackend interface {
+	// records returns every record, sorted by relative path.
+	records() ([]manifestRecord, error)
+	// add stores records for files organized by this run, skipping paths
+	// already in the manifest, and returns the number added.
+	add(recs []manifestRecord) (int, error)
+	// update replaces the records with the same relative paths, adding
+	// those not in the manifest yet.
+	update(recs []manifestRecord) error
+	// close releases the backend.
+	close() error
+}
+
+// openManifest opens the library's manifest: the SQLite catalog if the
+// library has been migrated to one, the CSV file otherwise.
+func openManifest() (manifestBackend, error) {
+	if _, err := os.Stat(catalogFile); err == nil {
+		return openCatalog(catalogFile)
+	}
+	return &csvManifest{path: manifestFile}, nil
+}
+
+// adoptedSourceFolder is the source_folder of files that were found in
+// Originals/ rather than organized into it (by fsck --fix or reindex).
+const adoptedSourceFolder = "(adopted)"
+
+// adoptedRecord builds the manifest record for a file found in Originals/,
+// from metadata read by the same extractors organize uses.
+func adoptedRecord(m fileMeta) manifestRecord {
+	relPath, _ := filepath.Rel(photoRoot, m.Path)
+	return manifestRecord{
+		Filename:      filepath.Base(m.Path),
+		RelativePath:  relPath,
+		SourceFolder:  adoptedSourceFolder,
+		Size:          m.Size,
+		Modified:      m.ModTime,
+		CaptureDate:   m.CaptureDate,
+		DateSource:    m.DateSource,
+		Camera:        m.Camera,
+		GPS:           m.GPS,
+		Place:         reverseGeocode(m.GPS),
+		Video:         m.Video,
+		Hash:          m.Hash,
+		Extension:     strings.ToLower(filepath.Ext(m.Path)),
+		OrganizedDate: time.Now(),
+	}
+}
+
+// updateManifest adds newly organized files to the manifest.
+func updateManifest(organized []FileInfo) error {
+	m, err := openManifest()
+	if err != nil {
+		return err
+	}
+	defer m.close()
+
+	recs := make([]manifestRecord, len(organized))
+	for i, fi := range organized {
+		recs[i] = newManifestRecord(fi)
+	}
+
+	newCount, err := m.add(recs)
+	if err != nil {
+		return err
+	}
+	if newCount > 0 {
+		logf(levelInfo, "Added %d entries to manifest\n", newCount)
+	}
+	return nil
+}
+
+// =============================================================================
+// CSV Manifest
+// =============================================================================
+
+// csvManifest is the manifest stored as a CSV file. Rows are read and
+// written by column name, so reordered and user-added columns survive; the
+// whole file is rewritten on every update.
+type csvManifest struct {
+	path string
+}
+
+// records implements manifestBackend.
+func (m *csvManifest) records() ([]manifestRecord, error) {
+	t, err := readManifest(m.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var recs []manifestRecord
+	for _, row := range t.rows {
+		if r := manifestRecordFromFields(row); r.RelativePath != "" {
+			recs = append(recs, r)
+		}
+	}
+	sort.Slice(recs, func(a, b int) bool { return recs[a].RelativePath < recs[b].RelativePath })
+	return recs, nil
+}
+
+// add implements manifestBackend.
+func (m *csvManifest) add(recs []manifestRecord) (int, error) {
+	newCount := 0
+	err := m.rewrite(func(rows map[string]map[string]string) {
+		for _, r := range recs {
+			if _, exists := rows[r.RelativePath]; exists {
+				continue // Skip if already in manifest
+			}
+			rows[r.RelativePath] = r.fields()
+			newCount++
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	return newCount, nil
+}
+
+// update implements manifestBackend. Columns the organizer doesn't know
+// keep their values.
+func (m *csvManifest) update(recs []manifestRecord) error {
+	return m.rewrite(func(rows map[string]map[string]string) {
+		for _, r := range recs {
+			row := rows[r.RelativePath]
+			if row == nil {
+				row = make(map[string]string)
+				rows[r.RelativePath] = row
+			}
+			for k, v := range r.fields() {
+				row[k] = v
+			}
+		}
+	})
+}
+
+// rewrite loads the manifest, upgraded to the current schema, lets change
+// edit its rows keyed by relative path, and rewrites the file sorted by
+// path. Rows with an empty or repeated relative path are reported and kept
+// unchanged. The previous manifest is backed up first and the new one is written
+// atomically. An existing manifest that cannot be parsed, or that has a
+// newer schema than this tool knows, is never overwritten.
+func (m *csvManifest) rewrite(change func(rows map[string]map[string]string)) error {
+	// Ensure manifest directory exists
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return err
+	}
+
+	t, err := readManifest(m.path)
+	if err != nil {
+		return fmt.Errorf("%v; refusing to overwrite it", err)
+	}
+	if t.schema > manifestSchemaVersion {
+		return fmt.Errorf("%s has schema version %d, newer than this tool supports (%d); refusing to overwrite it",
+			m.path, t.schema, manifestSchemaVersion)
+	}
+	oldSchema := t.schema
+	if added := t.upgrade(); len(added) > 0 {
+		logf(levelInfo, "Upgrading manifest from schema %d to %d (new columns: %s)\n",
+			oldSchema, manifestSchemaVersion, strings.Join(added, ", "))
+	}
+
+	// Key existing rows by relative path. Rows without one, or repeating
+	// one, can't be keyed; they are kept as they are rather than merged
+	rows := make(map[string]map[string]string)
+	var unkeyed []map[string]string
+	missing, duplicate := 0, 0
+	for _, row := range t.rows {
+		p := row["relative_path"]
+		switch _, dup := rows[p]; {
+		case p == "":
+			missing++
+		case dup:
+			duplicate++
+		default:
+			rows[p] = row
+			continue
+		}
+		unkeyed = append(unkeyed, row)
+	}
+	if missing > 0 || duplicate > 0 {
+		logf(levelWarn, "Warning: %s has %d rows without a relative_path and %d repeating one; they are kept as they are\n",
+			displayPath(m.path), missing, duplicate)
+	}
+
+	change(rows)
+
+	// Sort entries by relative path for consistent output; repeated rows
+	// follow the one that was updated
+	t.rows = t.rows[:0]
+	for _, row := range rows {
+		t.rows = append(t.rows, row)
+	}
+	sort.Slice(t.rows, func(a, b int) bool { return t.rows[a]["relative_path"] < t.rows[b]["relative_path"] })
+	t.rows = append(t.rows, unkeyed...)
+	sort.SliceStable(t.rows, func(a, b int) bool { return t.rows[a]["relative_path"] < t.rows[b]["relative_path"] })
+
+	if err := backupManifest(m.path); err != nil {
+		return fmt.Errorf("backing up manifest: %v", err)
+	}
+
+	// Write updated manifest
+	return writeFileAtomic(m.path, 0644, t.write)
+}
+
+// close implements manifestBackend; the CSV holds nothing open.
+func (m *csvManifest) close() error {
+	return nil
+}
+
+// writeManifestCSV writes recs as a manifest CSV with the current schema.
+func writeManifestCSV(w io.Writer, recs []manifestRecord) error {
+	return recordsTable(recs).write(w)
+}
+
+// writeRecordsCSV writes recs as plain CSV in the manifest's columns, with
+// the header on the first line and no schema marker, for tools that read
+// query results.
+func writeRecordsCSV(w io.Writer, recs []manifestRecord) error {
+	return recordsTable(recs).writeRows(w)
+}
+
+// recordsTable returns recs as a manifest table with the current schema.
+func recordsTable(recs []manifestRecord) *manifestTable {
+	t := &manifestTable{}
+	t.upgrade()
+	for _, r := range recs {
+		t.rows = append(t.rows, r.fields())
+	}
+	return t
+}
+
+// =============================================================================
+// Manifest Command
+// =============================================================================
+
+// runManifest implements the manifest subcommand.
+// Returns the process exit code.
+func runManifest(args []string) int {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Manage the manifest in _Manifest/\n\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "  %s manifest migrate [--root /path]              # Move the CSV manifest into a SQLite catalog\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s manifest export [--root /path] [--out file]  # Write the manifest as CSV\n", os.Args[0])
+	}
+	if len(args) == 0 {
+		usage()
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "migrate":
+		return runManifestMigrate(args[1:])
+	case "export":
+		return runManifestExport(args[1:])
+	}
+	usage()
+	return exitUsage
+}
+
+// runManifestMigrate imports the CSV manifest into a new SQLite catalog.
+// From then on the catalog is the manifest; the CSV is left in place.
+func runManifestMigrate(args []string) int {
+	fs := flag.NewFlagSet("manifest migrate", flag.ExitOnError)
+	rootDir := fs.String("root", "", "Photo library root directory (default: current directory)")
+	fs.Parse(args)
+
+	if err := setLibraryPaths(*rootDir); err != nil {
+		fmt.Println("Error getting current directory:", err)
+		return exitFatal
+	}
+	if _, err := os.Stat(catalogFile); err == nil {
+		fmt.Printf("Error: catalog already exists at %s\n", catalogFile)
+		return exitFatal
+	}
+
+	lock, err := acquireLibraryLock(0)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return exitFatal
+	}
+	defer lock.release()
+
+	recs, err := (&csvManifest{path: manifestFile}).records()
+	if err != nil {
+		fmt.Println("Error reading manifest:", err)
+		return exitFatal
+	}
+
+	if err := os.MkdirAll(manifestDir, 0755); err != nil {
+		fmt.Println("Error:", err)
+		return exitFatal
+	}
+	c, err := openCatalog(catalogFile)
+	if err != nil {
+		fmt.Println("Error creating catalog:", err)
+		return exitFatal
+	}
+	added, err := c.add(recs)
+	c.close()
+	if err != nil {
+		os.Remove(catalogFile) // Don't leave a partial catalog in charge
+		fmt.Println("Error migrating manifest:", err)
+		return exitFatal
+	}
+
+	fmt.Printf("Migrated %d manifest entries to %s\n", added, displayPath(catalogFile))
+	fmt.Printf("The catalog is now the manifest; %s is no longer updated\n", displayPath(manifestFile))
+	fmt.Printf("(use \"manifest export\" to write a current CSV)\n")
+	return exitOK
+}
+
+// runManifestExport writes the manifest, from whichever backend holds it,
+// as CSV.
+func runManifestExport(args []string) int {
+	fs := flag.NewFlagSet("manifest export", flag.ExitOnError)
+	rootDir := fs.String("root", "", "Photo library root directory (default: current directory)")
+	out := fs.String("out", "-", "File to write the CSV to (- for stdout)")
+	fs.Parse(args)
+
+	if err := setLibraryPaths(*rootDir); err != nil {
+		fmt.Fprintln(os.Stderr, "Error getting current directory:", err)
+		return exitFatal
+	}
+
+	m, err := openManifest()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error opening manifest:", err)
+		return exitFatal
+	}
+	defer m.close()
+
+	recs, err := m.records()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading manifest:", err)
+		return exitFatal
+	}
+
+	if *out == "-" {
+		err = writeManifestCSV(os.Stdout, recs)
+	} else {
+		err = writeFileAtomic(*out, 0644, func(w io.Writer) error {
+			return writeManifestCSV(w, recs)
+		})
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing CSV:", err)
+		return exitFatal
+	}
+	if *out != "-" {
+		fmt.Printf("Exported %d entries to %s\n", len(recs), *out)
+	}
+	return exitOK
+}
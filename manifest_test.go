@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testManifestRecord returns a manifest record with most fields filled in.
+func testManifestRecord(rel string) manifestRecord {
+	return manifestRecord{
+		Filename:      rel[strings.LastIndex(rel, "/")+1:],
+		RelativePath:  rel,
+		SourceFolder:  "Camera",
+		Size:          123456,
+		Modified:      time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local),
+		CaptureDate:   time.Date(2024, 6, 1, 11, 59, 58, 0, time.Local),
+		DateSource:    dateSourceExif,
+		Camera:        cameraInfo{Make: "Canon", Model: "EOS R5", ISO: 400},
+		GPS:           &gpsPosition{Lat: 48.8584, Lon: 2.2945},
+		Place:         placeName{City: "Paris", Region: "Ile-de-France", Country: "France"},
+		Hash:          "0123456789abcdef0123456789abcdef",
+		Extension:     ".jpg",
+		OrganizedDate: time.Date(2024, 6, 2, 9, 0, 0, 0, time.Local),
+		SourcePath:    "Incoming/Camera/" + rel[strings.LastIndex(rel, "/")+1:],
+	}
+}
+
+// writeManifestFile replaces the library's manifest CSV with content.
+func writeManifestFile(t *testing.T, content string) {
+	t.Helper()
+	if err := os.MkdirAll(manifestDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(manifestFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// spreadsheetSave rewrites a manifest the way a spreadsheet saves it: the
+// marker becomes the first cell of a row padded to the header width.
+func spreadsheetSave(manifest string, quote bool, eol string) string {
+	lines := strings.Split(strings.TrimSuffix(manifest, "\n"), "\n")
+	marker := lines[0]
+	if quote {
+		marker = `"` + marker + `"`
+	}
+	lines[0] = marker + strings.Repeat(",", len(manifestColumns)-1)
+	return strings.Join(lines, eol) + eol
+}
+
+func TestReadManifestSpreadsheetMarker(t *testing.T) {
+	tests := []struct {
+		name  string
+		quote bool
+		eol   string
+		bom   bool
+	}{
+		{name: "trailing commas", eol: "\n"},
+		{name: "quoted", quote: true, eol: "\n"},
+		{name: "quoted with CRLF and BOM", quote: true, eol: "\r\n", bom: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestLibrary(t)
+			want := testManifestRecord("Originals/2024/2024-06-01/IMG_0001.JPG")
+
+			var buf bytes.Buffer
+			if err := writeManifestCSV(&buf, []manifestRecord{want}); err != nil {
+				t.Fatal(err)
+			}
+			saved := spreadsheetSave(buf.String(), tt.quote, tt.eol)
+			if tt.bom {
+				saved = "\ufeff" + saved
+			}
+			writeManifestFile(t, saved)
+
+			table, err := readManifest(manifestFile)
+			if err != nil {
+				t.Fatalf("readManifest: %v", err)
+			}
+			if table.schema != manifestSchemaVersion {
+				t.Errorf("schema = %d, want %d", table.schema, manifestSchemaVersion)
+			}
+			if len(table.headers) != len(manifestColumns) || table.headers[0] != "filename" {
+				t.Errorf("headers = %q, want the manifest columns", table.headers)
+			}
+
+			// Updating the manifest must write it back with a clean marker
+			m := &csvManifest{path: manifestFile}
+			if err := m.update([]manifestRecord{want}); err != nil {
+				t.Fatalf("update: %v", err)
+			}
+			data, err := os.ReadFile(manifestFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if first, _, _ := strings.Cut(string(data), "\n"); first != "# photo-organizer manifest schema 6" {
+				t.Errorf("marker written as %q", first)
+			}
+
+			recs, err := m.records()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(recs) != 1 {
+				t.Fatalf("got %d records, want 1", len(recs))
+			}
+			if got := recs[0].fields(); !sameFields(got, want.fields()) {
+				t.Errorf("record = %v, want %v", got, want.fields())
+			}
+		})
+	}
+}
+
+func TestReadManifestBadMarker(t *testing.T) {
+	newTestLibrary(t)
+	writeManifestFile(t, "\"# photo-organizer manifest schema six\",,,\nfilename\n")
+	if _, err := readManifest(manifestFile); err == nil {
+		t.Fatal("readManifest accepted a non-numeric schema version")
+	}
+}
+
+func TestManifestSchemaUpgrade(t *testing.T) {
+	newTestLibrary(t)
+
+	// A schema 2 manifest, with a column the user added
+	writeManifestFile(t, "# photo-organizer manifest schema 2\n"+
+		"filename,relative_path,source_folder,file_size_bytes,file_size_mb,file_modified,"+
+		"capture_date,camera_make,camera_model,file_hash,extension,organized_date,"+
+		"date_source,source_path,notes\n"+
+		"a.jpg,Originals/2020/2020-01-01/a.jpg,Camera,100,0.00,2020-01-01 10:00:00,"+
+		"2020:01:01 09:00:00,Nikon,D750,abc,.jpg,2020-01-02 10:00:00,exif,Incoming/Camera/a.jpg,keep me\n")
+
+	table, err := readManifest(manifestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.schema != 2 {
+		t.Fatalf("schema = %d, want 2", table.schema)
+	}
+	added := table.upgrade()
+	if table.schema != manifestSchemaVersion {
+		t.Errorf("schema after upgrade = %d, want %d", table.schema, manifestSchemaVersion)
+	}
+
+	var want []string
+	for _, c := range manifestColumns {
+		if c.since > 2 {
+			want = append(want, c.name)
+		}
+	}
+	if strings.Join(added, ",") != strings.Join(want, ",") {
+		t.Errorf("added columns = %q, want %q", added, want)
+	}
+	if table.headers[14] != "notes" || table.headers[15] != want[0] {
+		t.Errorf("headers = %q: existing columns must keep their place, new ones go last", table.headers)
+	}
+
+	// Rewriting keeps the user's column and its value
+	m := &csvManifest{path: manifestFile}
+	b := testManifestRecord("Originals/2024/2024-06-01/b.jpg")
+	if _, err := m.add([]manifestRecord{b}); err != nil {
+		t.Fatal(err)
+	}
+	table, err = readManifest(manifestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.schema != manifestSchemaVersion {
+		t.Errorf("rewritten schema = %d, want %d", table.schema, manifestSchemaVersion)
+	}
+	if got := table.rows[0]["notes"]; got != "keep me" {
+		t.Errorf("notes = %q, want %q", got, "keep me")
+	}
+	if got := table.rows[1]["place_city"]; got != "Paris" {
+		t.Errorf("new record place_city = %q, want Paris", got)
+	}
+}
+
+func TestManifestColumnsByName(t *testing.T) {
+	newTestLibrary(t)
+
+	// Columns reordered and some dropped, as a spreadsheet user might
+	writeManifestFile(t, "# photo-organizer manifest schema 6\n"+
+		"notes,capture_date,relative_path,camera_model,file_size_bytes,gps_longitude,gps_latitude,duration_s,video_codec\n"+
+		"hello,2023:07:04 18:30:00,Originals/2023/2023-07-04/clip.mp4,iPhone 15,4096,-122.4194,37.7749,12.5,hvc1\n")
+
+	recs, err := (&csvManifest{path: manifestFile}).records()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 {
+		t.Fatalf("got %d records, want 1", len(recs))
+	}
+	r := recs[0]
+	if r.RelativePath != "Originals/2023/2023-07-04/clip.mp4" {
+		t.Errorf("RelativePath = %q", r.RelativePath)
+	}
+	if want := time.Date(2023, 7, 4, 18, 30, 0, 0, time.Local); !r.CaptureDate.Equal(want) {
+		t.Errorf("CaptureDate = %v, want %v", r.CaptureDate, want)
+	}
+	if r.Camera.Model != "iPhone 15" || r.Camera.Make != "" {
+		t.Errorf("Camera = %+v", r.Camera)
+	}
+	if r.Size != 4096 {
+		t.Errorf("Size = %d, want 4096", r.Size)
+	}
+	if r.GPS == nil || r.GPS.Lat != 37.7749 || r.GPS.Lon != -122.4194 {
+		t.Errorf("GPS = %+v", r.GPS)
+	}
+	if r.Video.Duration != 12.5 || r.Video.Codec != "hvc1" {
+		t.Errorf("Video = %+v", r.Video)
+	}
+}
+
+// sameFields reports whether two manifest rows have the same values.
+func sameFields(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func TestWriteRecordsCSV(t *testing.T) {
+	var buf bytes.Buffer
+	recs := []manifestRecord{testManifestRecord("Originals/2024/2024-06-01/IMG_0001.JPG")}
+	if err := writeRecordsCSV(&buf, recs); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want a header and one row:\n%s", len(lines), buf.String())
+	}
+	if !strings.HasPrefix(lines[0], "filename,relative_path,") {
+		t.Errorf("first line = %q, want the header", lines[0])
+	}
+}
+
+func TestCSVManifestOtherPath(t *testing.T) {
+	newTestLibrary(t)
+	writeManifestFile(t, "# photo-organizer manifest schema 6\nrelative_path\nOriginals/library.jpg\n")
+	library, _ := os.ReadFile(manifestFile)
+
+	other := &csvManifest{path: filepath.Join(t.TempDir(), "other.csv")}
+	if err := other.update([]manifestRecord{testManifestRecord("Originals/other.jpg")}); err != nil {
+		t.Fatal(err)
+	}
+	recs, err := other.records()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 || recs[0].RelativePath != "Originals/other.jpg" {
+		t.Errorf("records = %+v, want only Originals/other.jpg", recs)
+	}
+	if data, _ := os.ReadFile(manifestFile); !bytes.Equal(data, library) {
+		t.Errorf("library manifest changed:\n%s", data)
+	}
+}
+
+func TestCSVManifestKeepsUnkeyedRows(t *testing.T) {
+	newTestLibrary(t)
+	writeManifestFile(t, "# photo-organizer manifest schema 6\n"+
+		"relative_path,notes\n"+
+		"Originals/b.jpg,first\n"+
+		",no path\n"+
+		"Originals/b.jpg,second\n"+
+		",no path either\n"+
+		"Originals/a.jpg,a\n")
+
+	m := &csvManifest{path: manifestFile}
+	if _, err := m.add([]manifestRecord{testManifestRecord("Originals/c.jpg")}); err != nil {
+		t.Fatal(err)
+	}
+	table, err := readManifest(manifestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, row := range table.rows {
+		got = append(got, row["relative_path"]+"="+row["notes"])
+	}
+	want := []string{"=no path", "=no path either", "Originals/a.jpg=a",
+		"Originals/b.jpg=first", "Originals/b.jpg=second", "Originals/c.jpg="}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("rows = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// =============================================================================
+// Machine-Readable Output
+// =============================================================================
+//
+// With --output json, stdout carries newline-delimited JSON: one event per
+// file decision, then a single summary object. The human-readable text goes
+// to stderr instead. Field names are stable; incompatible changes bump
+// eventSchemaVersion.
+
+// eventSchemaVersion is the version of the JSON event schema.
+const eventSchemaVersion = 1
+
+// Event types.
+const (
+	eventPlanned   = "planned"              // Dry run: would be transferred
+	eventMoved     = "moved"                // Transferred (in the run's mode, see "mode")
+	eventDuplicate = "skipped-duplicate"    // Same name and size already at destination
+	eventImported  = "skipped-imported"     // Already imported by an earlier run
+	eventRenamed   = "renamed-on-collision" // Destination name taken; a suffix was added
+	eventError     = "error"                // An operation on a file failed
+	eventCreated   = "created"              // init: directory created
+	eventExists    = "exists"               // init: directory already existed
+	eventInstalled = "installed"            // install-skill: skill file written
+	eventSummary   = "summary"              // Final object of every run
+)
+
+// outputEvent is one NDJSON event. Paths are relative to the photo root, or
+// absolute if outside it.
+type outputEvent struct {
+	Schema      int          `json:"schema"`
+	Event       string       `json:"event"`
+	Source      string       `json:"source,omitempty"`
+	Destination string       `json:"destination,omitempty"`
+	Path        string       `json:"path,omitempty"`
+	Mode        transferMode `json:"mode,omitempty"`
+	Size        int64        `json:"size,omitempty"`
+	CaptureDate string       `json:"capture_date,omitempty"`
+	DateSource  string       `json:"date_source,omitempty"`
+	Op          string       `json:"op,omitempty"`
+	Error       string       `json:"error,omitempty"`
+}
+
+// outputSummary is the final NDJSON object of a run.
+type outputSummary struct {
+	Schema   int            `json:"schema"`
+	Event    string         `json:"event"`
+	Command  string         `json:"command"`
+	DryRun   bool           `json:"dry_run"`
+	OK       bool           `json:"ok"`
+	ExitCode int            `json:"exit_code"`
+	Counts   map[string]int `json:"counts"`
+	Error    string         `json:"error,omitempty"`
+	Finished time.Time      `json:"finished"`
+}
+
+var (
+	eventOut    io.Writer // Destination of JSON events; nil in text mode
+	eventMu     sync.Mutex
+	eventCounts = make(map[string]int) // Events emitted so far, by type
+)
+
+// setOutputFormat selects "text" (the default) or "json" output.
+// In JSON mode the real stdout is kept for events and os.Stdout is pointed at
+// stderr, so all human-readable output moves there.
+func setOutputFormat(format string) error {
+	switch format {
+	case "text":
+		return nil
+	case "json":
+		eventOut = os.Stdout
+		os.Stdout = os.Stderr
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q (expected text or json)", format)
+}
+
+// emitEvent counts e and, in JSON mode, writes it as one line.
+// Safe for concurrent use.
+func emitEvent(e outputEvent) {
+	eventMu.Lock()
+	defer eventMu.Unlock()
+
+	eventCounts[e.Event]++
+	if eventOut == nil {
+		return
+	}
+	e.Schema = eventSchemaVersion
+	json.NewEncoder(eventOut).Encode(e)
+}
+
+// planEvent builds the event for a plan entry.
+func planEvent(event string, e planEntry, mode transferMode) outputEvent {
+	ev := outputEvent{
+		Event:      event,
+		Source:     libraryRelPath(e.SrcPath),
+		Mode:       mode,
+		Size:       e.Size,
+		DateSource: e.DateSource,
+	}
+	if e.DestPath != "" {
+		ev.Destination = libraryRelPath(e.DestPath)
+	}
+	if !e.CaptureDate.IsZero() {
+		ev.CaptureDate = e.CaptureDate.Format(time.RFC3339)
+	}
+	return ev
+}
+
+// emitSummary writes the summary object in JSON mode. code is the process
+// exit code, and err the fatal error that ended the run, if any.
+func emitSummary(command string, dryRun bool, code int, err error) {
+	eventMu.Lock()
+	defer eventMu.Unlock()
+
+	if eventOut == nil {
+		return
+	}
+	s := outputSummary{
+		Schema:   eventSchemaVersion,
+		Event:    eventSummary,
+		Command:  command,
+		DryRun:   dryRun,
+		OK:       err == nil && eventCounts[eventError] == 0,
+		ExitCode: code,
+		Counts:   make(map[string]int),
+		Finished: time.Now(),
+	}
+	for t, n := range eventCounts {
+		s.Counts[t] = n
+	}
+	if err != nil {
+		s.Error = err.Error()
+	}
+	json.NewEncoder(eventOut).Encode(s)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// =============================================================================
+// Processing Pipeline
+// =============================================================================
+//
+// A run is processed in stages: discover (walk the source) -> extract
+// (metadata for every file) -> plan (destinations and conflicts) -> execute
+// (transfers). Extract and execute are I/O-bound and run on a bounded worker
+// pool; discover and plan are sequential so the result does not depend on
+// scheduling.
+
+// jobs is the number of files processed concurrently in the extract and
+// execute stages, set by the --jobs flag.
+var jobs = runtime.NumCPU()
+
+// fileMeta is everything the planner needs to know about a source file.
+// It is gathered once per file, with a single open.
+type fileMeta struct {
+	Path        string       // Source file
+	Size        int64        // Size in bytes
+	ModTime     time.Time    // Modification time
+	CaptureDate time.Time    // Best available capture date
+	DateSource  string       // Where CaptureDate came from (dateSource*)
+	Camera      cameraInfo   // EXIF camera, lens and exposure
+	GPS         *gpsPosition // EXIF GPS position; nil if none
+	Video       videoInfo    // Container duration, frame rate and codec (videos)
+	Hash        string       // MD5 of the first 64KB
+	EventLabel  string       // Appended to the day folder name (set during review)
+	Imported    bool         // Already in the import ledger; nothing else was read
+	Err         error        // Set if the file could not be read
+}
+
+// readFileMeta gathers a file's size, capture date, camera and partial
+// hash. Unchanged files are served from the metadata cache; otherwise the
+// file is opened only once.
+func readFileMeta(path string) fileMeta {
+	m := fileMeta{Path: path}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		m.Err = err
+		return m
+	}
+	m.Size = info.Size()
+	m.ModTime = info.ModTime()
+
+	if e, ok := metaCache.lookup(path, info); ok && e.DateSource != "" && e.Hash != "" {
+		m.CaptureDate, m.DateSource = e.CaptureDate, e.DateSource
+		m.Camera, m.GPS, m.Video = e.Camera, e.GPS, e.Video
+		m.Hash = e.Hash
+		logf(levelDebug, "%s: date %s from %s (cached)\n", displayPath(path), m.CaptureDate.Format(time.RFC3339), m.DateSource)
+		if m.DateSource == dateSourceMtime || m.DateSource == dateSourceNow {
+			noteDateFallback(path, m.CaptureDate, m.DateSource)
+		}
+		return m
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		m.Err = err
+		return m
+	}
+	defer f.Close()
+
+	m.Hash = partialHash(f)
+
+	// Rewind so EXIF is decoded from the same handle
+	var x *exif.Exif
+	if isPhotoFile(filepath.Ext(path)) {
+		if _, err := f.Seek(0, io.SeekStart); err == nil {
+			x, _ = exif.Decode(f)
+		}
+	}
+	m.Camera, m.GPS = exifCamera(x), exifGPS(x)
+	if isVideoFile(filepath.Ext(path)) {
+		if _, err := f.Seek(0, io.SeekStart); err == nil {
+			m.Video, m.Camera.Width, m.Camera.Height = readVideoInfo(f, filepath.Ext(path))
+		}
+	}
+	m.CaptureDate, m.DateSource = resolveFileDate(path, info, x)
+
+	metaCache.update(path, info, func(e *cacheEntry) {
+		e.CaptureDate, e.DateSource = m.CaptureDate, m.DateSource
+		e.Camera, e.GPS, e.Video = m.Camera, m.GPS, m.Video
+		e.Hash = m.Hash
+	})
+
+	return m
+}
+
+// extractMetadata reads metadata for files on the worker pool.
+// Files already recorded in ledger (if not nil, relative to srcRoot) are
+// only stat'ed and marked Imported. Results are in the same order as files.
+func extractMetadata(srcRoot string, files []string, ledger *importLedger) []fileMeta {
+	metas := make([]fileMeta, len(files))
+
+	parallelOrdered(len(files), func(i int) fileMeta {
+		path := files[i]
+		if ledger != nil {
+			rel, _ := filepath.Rel(srcRoot, path)
+			if info, err := os.Stat(path); err == nil && ledger.contains(rel, info.Size(), info.ModTime()) {
+				return fileMeta{Path: path, Size: info.Size(), ModTime: info.ModTime(), Imported: true}
+			}
+		}
+		return readFileMeta(path)
+	}, func(i int, m fileMeta) {
+		metas[i] = m
+	})
+
+	return metas
+}
+
+// parallelOrdered runs work(i) for every i in [0, n) on up to jobs goroutines.
+// emit(i, result) is called on the calling goroutine in index order as soon
+// as each result and all earlier ones are ready, so output stays
+// deterministic however the work is scheduled.
+func parallelOrdered[R any](n int, work func(i int) R, emit func(i int, r R)) {
+	workers := jobs
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+
+	type result struct {
+		i int
+		r R
+	}
+	next := make(chan int)
+	results := make(chan result, workers)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results <- result{i, work(i)}
+			}
+		}()
+	}
+	go func() {
+		for i := 0; i < n; i++ {
+			next <- i
+		}
+		close(next)
+		wg.Wait()
+		close(results)
+	}()
+
+	pending := make(map[int]R)
+	want := 0
+	for res := range results {
+		pending[res.i] = res.r
+		for {
+			r, ok := pending[want]
+			if !ok {
+				break
+			}
+			delete(pending, want)
+			emit(want, r)
+			want++
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// Plan Files
+// =============================================================================
+
+// planFileVersion is the format version written to plan files.
+const planFileVersion = 1
+
+// planFile is the JSON document written by --plan-out and executed by apply.
+// Paths are relative to the photo root, or absolute if outside it.
+type planFile struct {
+	Version int             `json:"version"`
+	Created time.Time       `json:"created"`
+	Root    string          `json:"root"`
+	Mode    transferMode    `json:"mode"`
+	Layout  string          `json:"layout,omitempty"`
+	Entries []planFileEntry `json:"entries"`
+}
+
+// planFileEntry is one decision in a plan file.
+type planFileEntry struct {
+	Source       string       `json:"source"`
+	Destination  string       `json:"destination,omitempty"`
+	Action       planAction   `json:"action"`
+	Reason       string       `json:"reason"`
+	SourceFolder string       `json:"source_folder"`
+	Size         int64        `json:"size"`
+	Modified     time.Time    `json:"modified"`
+	CaptureDate  string       `json:"capture_date,omitempty"`
+	DateSource   string       `json:"date_source,omitempty"`
+	Camera       cameraInfo   `json:"camera"`
+	GPS          *gpsPosition `json:"gps,omitempty"`
+	Video        videoInfo    `json:"video"`
+	Hash         string       `json:"hash"`
+	SHA256       string       `json:"sha256,omitempty"`
+	Duplicate    bool         `json:"duplicate"`
+	Renamed      bool         `json:"renamed"`
+}
+
+// planReason explains a plan decision in words.
+func planReason(e planEntry) string {
+	switch e.Action {
+	case actionDuplicate:
+		return "same name and size already at destination"
+	case actionImported:
+		return "already imported by an earlier run"
+	}
+	reason := fmt.Sprintf("captured %s (from %s)", e.CaptureDate.Format("2006-01-02"), e.DateSource)
+	if e.Renamed {
+		reason += "; renamed, a different file has this name at the destination"
+	}
+	return reason
+}
+
+// writePlanFile writes plan to path as JSON.
+// sourceFolder names the manifest source_folder for each source path.
+func writePlanFile(path string, plan []planEntry, mode transferMode, sourceFolder func(string) string) error {
+	pf := planFile{
+		Version: planFileVersion,
+		Created: time.Now(),
+		Root:    absLibraryRoot(photoRoot),
+		Mode:    mode,
+		Layout:  folderLayout,
+		Entries: []planFileEntry{},
+	}
+
+	for _, e := range plan {
+		fe := planFileEntry{
+			Source:       libraryRelPath(e.SrcPath),
+			Action:       e.Action,
+			Reason:       planReason(e),
+			SourceFolder: sourceFolder(e.SrcPath),
+			Size:         e.Size,
+			Modified:     e.ModTime,
+			DateSource:   e.DateSource,
+			Camera:       e.Camera,
+			GPS:          e.GPS,
+			Video:        e.Video,
+			Hash:         e.Hash,
+			Duplicate:    e.Action == actionDuplicate,
+			Renamed:      e.Renamed,
+		}
+		if info, err := os.Stat(e.SrcPath); err == nil {
+			if ce, ok := metaCache.lookup(e.SrcPath, info); ok {
+				fe.SHA256 = ce.SHA256
+			}
+		}
+		if e.DestPath != "" {
+			fe.Destination = libraryRelPath(e.DestPath)
+		}
+		if !e.CaptureDate.IsZero() {
+			fe.CaptureDate = e.CaptureDate.Format(time.RFC3339)
+		}
+		pf.Entries = append(pf.Entries, fe)
+	}
+
+	return writeFileAtomic(path, 0644, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(pf)
+	})
+}
+
+// readPlanFile reads a plan file written by writePlanFile.
+func readPlanFile(path string) (*planFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var pf planFile
+	if err := json.Unmarshal(data, &pf); err != nil {
+		return nil, fmt.Errorf("cannot parse plan %s: %v", path, err)
+	}
+	if pf.Version != planFileVersion {
+		return nil, fmt.Errorf("plan %s has unsupported version %d (expected %d)", path, pf.Version, planFileVersion)
+	}
+	if _, err := parseTransferMode(string(pf.Mode)); err != nil {
+		return nil, fmt.Errorf("plan %s: %v", path, err)
+	}
+	return &pf, nil
+}
+
+// checkPlanDrift re-validates a planned transfer against the file system.
+// Returns "" if the entry can be applied as planned, or a description of
+// what changed since the plan was made. The content is compared by full
+// SHA-256 when the plan recorded one, and by partial hash otherwise.
+func checkPlanDrift(fe planFileEntry) string {
+	src := resolveLibraryPath(fe.Source)
+	info, err := os.Stat(src)
+	if err != nil {
+		return "source is missing"
+	}
+	if info.Size() != fe.Size {
+		return fmt.Sprintf("source size changed (%d → %d bytes)", fe.Size, info.Size())
+	}
+	if !info.ModTime().Equal(fe.Modified) {
+		return fmt.Sprintf("source modified (%s → %s)",
+			fe.Modified.Format("2006-01-02 15:04:05"), info.ModTime().Format("2006-01-02 15:04:05"))
+	}
+
+	// Hash the file itself; the cache trusts mtime, this must not
+	if fe.SHA256 != "" {
+		full, err := hashFile(src)
+		if err != nil {
+			return fmt.Sprintf("source unreadable: %v", err)
+		}
+		if full != fe.SHA256 {
+			return "source content changed"
+		}
+	} else {
+		f, err := os.Open(src)
+		if err != nil {
+			return fmt.Sprintf("source unreadable: %v", err)
+		}
+		hash := partialHash(f)
+		f.Close()
+		if hash != fe.Hash {
+			return "source content changed"
+		}
+	}
+
+	if _, err := os.Lstat(resolveLibraryPath(fe.Destination)); err == nil {
+		return "destination already exists"
+	}
+	return ""
+}
+
+// absLibraryRoot returns root as an absolute, cleaned path, so the same
+// library compares equal however it was named on the command line.
+func absLibraryRoot(root string) string {
+	if abs, err := filepath.Abs(root); err == nil {
+		return abs
+	}
+	return filepath.Clean(root)
+}
+
+// runApply implements the apply subcommand: execute exactly the transfers
+// in a plan file. Every planned transfer is re-validated first; entries whose
+// source changed or whose destination got taken are reported as drift and
+// skipped, never re-planned.
+// Returns the process exit code: exitPartial if any entry drifted.
+func runApply(args []string) int {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	rootDir := fs.String("root", "", "Photo library root directory (default: current directory)")
+	updateManifestFlag := fs.Bool("update-manifest", false, "Update the manifest CSV after applying")
+	updateManifestShort := fs.Bool("m", false, "Update manifest (short for --update-manifest)")
+	reserveFlag := fs.String("reserve", "1GB", "Free space to keep on the destination filesystem (e.g. 500MB, 10GB)")
+	fs.IntVar(&jobs, "jobs", jobs, "Number of files to process in parallel")
+	waitFlag := fs.Duration("wait", 0, "Wait up to this long if another run has the library locked (e.g. 10m)")
+	logOpts := addLogFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Execute a plan written by --plan-out, exactly as reviewed\n\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "  %s apply [options] <plan.json>\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	if err := logOpts.apply(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitUsage
+	}
+	doUpdateManifest := *updateManifestFlag || *updateManifestShort
+
+	var err error
+	spaceReserve, err = parseSize(*reserveFlag)
+	if err != nil {
+		logf(levelError, "Error: --reserve: %v\n", err)
+		return exitFatal
+	}
+	if err := setLibraryPaths(*rootDir); err != nil {
+		logf(levelError, "Error getting current directory: %v\n", err)
+		return exitFatal
+	}
+	if err := openRunLog("apply"); err != nil {
+		logf(levelWarn, "Warning: could not open run log: %v\n", err)
+	}
+
+	pf, err := readPlanFile(fs.Arg(0))
+	if err != nil {
+		logf(levelError, "Error: %v\n", err)
+		return exitFatal
+	}
+	mode := pf.Mode
+
+	// Print banner
+	logf(levelInfo, "%s\n", strings.Repeat("=", 50))
+	logf(levelInfo, "Photo Organizer - Apply Plan\n")
+	logf(levelInfo, "%s\n", strings.Repeat("=", 50))
+	logf(levelInfo, "Plan:      %s (created %s)\n", fs.Arg(0), pf.Created.Format("2006-01-02 15:04:05"))
+	logf(levelInfo, "Originals: %s\n", originalsDir)
+	logf(levelInfo, "Mode:      %s\n", mode)
+	logf(levelInfo, "\n")
+
+	if absLibraryRoot(pf.Root) != absLibraryRoot(photoRoot) {
+		logf(levelError, "Error: plan was made for the library at %s, not %s\n", pf.Root, photoRoot)
+		return exitFatal
+	}
+
+	lock, err := acquireLibraryLock(*waitFlag)
+	if err != nil {
+		logf(levelError, "Error: %v\n", err)
+		return exitFatal
+	}
+	defer lock.release()
+
+	// The plan's destinations follow its layout; later runs should too
+	if pf.Layout != "" {
+		if err := parseLayout(pf.Layout); err != nil {
+			logf(levelError, "Error: plan %s: %v\n", fs.Arg(0), err)
+			return exitFatal
+		}
+		folderLayout = pf.Layout
+		if err := saveFolderLayout(); err != nil {
+			logf(levelError, "Error saving folder layout: %v\n", err)
+			return exitFatal
+		}
+	}
+
+	// Re-validate every transfer; anything that changed is drift
+	var plan []planEntry
+	sourceFolders := make(map[string]string)
+	drifted := 0
+	for _, fe := range pf.Entries {
+		if fe.Action != actionTransfer {
+			continue
+		}
+		if drift := checkPlanDrift(fe); drift != "" {
+			logf(levelWarn, "  Drift: %s: %s\n", fe.Source, drift)
+			recordFailure(resolveLibraryPath(fe.Source), "apply", errors.New(drift))
+			drifted++
+			continue
+		}
+
+		entry := planEntry{
+			SrcPath:    resolveLibraryPath(fe.Source),
+			DestPath:   resolveLibraryPath(fe.Destination),
+			Size:       fe.Size,
+			ModTime:    fe.Modified,
+			DateSource: fe.DateSource,
+			Camera:     fe.Camera,
+			GPS:        fe.GPS,
+			Video:      fe.Video,
+			Hash:       fe.Hash,
+			Action:     actionTransfer,
+			Renamed:    fe.Renamed,
+		}
+		entry.CaptureDate, _ = time.Parse(time.RFC3339, fe.CaptureDate)
+		sourceFolders[entry.SrcPath] = fe.SourceFolder
+		plan = append(plan, entry)
+	}
+	if drifted > 0 {
+		logf(levelWarn, "\n%d planned transfers drifted since the plan was made and will not be applied\n\n", drifted)
+	}
+
+	if err := checkFreeSpace(plan, mode, false); err != nil {
+		logf(levelError, "Error: %v\n", err)
+		return exitFatal
+	}
+
+	var ledger *importLedger
+	if mode.keepsSource() {
+		ledger, err = loadImportLedger(incomingLedgerFile)
+		if err != nil {
+			logf(levelError, "Error: %v\n", err)
+			return exitFatal
+		}
+	}
+
+	organized := executePlan(plan, mode, false, ledger, incomingDir, func(src string) string {
+		return sourceFolders[src]
+	})
+
+	if ledger != nil {
+		if err := ledger.save(); err != nil {
+			logf(levelError, "Error saving import ledger: %v\n", err)
+			recordFailure(incomingLedgerFile, "save import ledger", err)
+		}
+	}
+	if len(organized) > 0 && doUpdateManifest {
+		if err := updateManifest(organized); err != nil {
+			logf(levelError, "Error updating manifest: %v\n", err)
+			recordFailure(manifestFile, "update manifest", err)
+		}
+	}
+	if !mode.keepsSource() {
+		cleanupEmptyFolders()
+	}
+
+	code := finishRun()
+	logf(levelInfo, "\nDone!\n")
+	return code
+}
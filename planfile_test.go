@@ -0,0 +1,162 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestLibrary points the library paths at a fresh temporary directory.
+func newTestLibrary(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	if err := setLibraryPaths(root); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+// writeTestFile creates a file under the library root and returns its path.
+func writeTestFile(t *testing.T, rel, content string) string {
+	t.Helper()
+	path := filepath.Join(photoRoot, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// plannedEntry describes the file at path the way writePlanFile would.
+func plannedEntry(t *testing.T, path string, full bool) planFileEntry {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	fe := planFileEntry{
+		Source:      libraryRelPath(path),
+		Destination: "Originals/2024/2024-06-01/" + filepath.Base(path),
+		Action:      actionTransfer,
+		Size:        info.Size(),
+		Modified:    info.ModTime(),
+		Hash:        partialHash(f),
+	}
+	if full {
+		if fe.SHA256, err = hashFile(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fe
+}
+
+func TestCheckPlanDrift(t *testing.T) {
+	// A file larger than the partial hash window, so a change past 64KB is
+	// only caught by the full hash
+	big := strings.Repeat("a", 70000)
+
+	tests := []struct {
+		name   string
+		full   bool                            // Record the full SHA-256 in the plan
+		change func(t *testing.T, path string) // What happens between plan and apply
+		want   string                          // Expected drift; "" for none
+	}{
+		{
+			name: "unchanged",
+		},
+		{
+			name: "unchanged with full hash",
+			full: true,
+		},
+		{
+			name:   "source removed",
+			change: func(t *testing.T, path string) { os.Remove(path) },
+			want:   "source is missing",
+		},
+		{
+			name: "size changed",
+			change: func(t *testing.T, path string) {
+				os.WriteFile(path, []byte(big+"b"), 0644)
+			},
+			want: "source size changed",
+		},
+		{
+			name: "modified in place",
+			change: func(t *testing.T, path string) {
+				later := time.Now().Add(time.Hour)
+				os.Chtimes(path, later, later)
+			},
+			want: "source modified",
+		},
+		{
+			name: "content changed past partial hash, same mtime",
+			full: true,
+			change: func(t *testing.T, path string) {
+				info, _ := os.Stat(path)
+				os.WriteFile(path, []byte(big[:69999]+"b"), 0644)
+				os.Chtimes(path, info.ModTime(), info.ModTime())
+			},
+			want: "source content changed",
+		},
+		{
+			name: "content changed in partial hash, same mtime",
+			change: func(t *testing.T, path string) {
+				info, _ := os.Stat(path)
+				os.WriteFile(path, []byte("b"+big[1:]), 0644)
+				os.Chtimes(path, info.ModTime(), info.ModTime())
+			},
+			want: "source content changed",
+		},
+		{
+			name: "destination taken",
+			change: func(t *testing.T, path string) {
+				writeTestFile(t, "Originals/2024/2024-06-01/IMG_0001.JPG", "other")
+			},
+			want: "destination already exists",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestLibrary(t)
+			path := writeTestFile(t, "Incoming/Camera/IMG_0001.JPG", big)
+			fe := plannedEntry(t, path, tt.full)
+			if tt.change != nil {
+				tt.change(t, path)
+			}
+
+			got := checkPlanDrift(fe)
+			if tt.want == "" && got != "" {
+				t.Fatalf("checkPlanDrift = %q, want no drift", got)
+			}
+			if !strings.HasPrefix(got, tt.want) {
+				t.Fatalf("checkPlanDrift = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAbsLibraryRoot(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, root := range []string{".", "./", wd + "/", filepath.Join(wd, "x", "..")} {
+		if got := absLibraryRoot(root); got != wd {
+			t.Errorf("absLibraryRoot(%q) = %q, want %q", root, got, wd)
+		}
+	}
+	if absLibraryRoot(filepath.Join(wd, "other")) == wd {
+		t.Errorf("a different directory compared equal to %q", wd)
+	}
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// =============================================================================
+// Manifest Queries
+// =============================================================================
+
+// manifestQuery selects manifest records. Zero-valued fields don't filter.
+type manifestQuery struct {
+	from, to    time.Time       // Capture date range; to is exclusive
+	extensions  map[string]bool // Lowercase, with the dot
+	source      string          // source_folder, case-insensitive
+	camera      string          // Substring of "make model", case-insensitive
+	place       string          // Substring of the place name, case-insensitive
+	minSize     uint64
+	maxSize     uint64
+	dateSources map[string]bool
+	minDuration time.Duration   // Videos at least this long
+	maxDuration time.Duration   // Videos at most this long
+	minLines    int             // Shorter side of the frame, in pixels
+	codecs      map[string]bool // Lowercase FourCCs
+	located     bool            // Only records with a GPS position
+}
+
+// match reports whether r passes every filter of q.
+func (q *manifestQuery) match(r manifestRecord) bool {
+	if !q.from.IsZero() && r.CaptureDate.Before(q.from) {
+		return false
+	}
+	if !q.to.IsZero() && !r.CaptureDate.Before(q.to) {
+		return false
+	}
+	if q.extensions != nil && !q.extensions[strings.ToLower(r.Extension)] {
+		return false
+	}
+	if q.source != "" && !strings.EqualFold(r.SourceFolder, q.source) {
+		return false
+	}
+	if q.camera != "" {
+		camera := strings.ToLower(r.Camera.Make + " " + r.Camera.Model)
+		if !strings.Contains(camera, strings.ToLower(q.camera)) {
+			return false
+		}
+	}
+	if q.place != "" && !strings.Contains(strings.ToLower(r.Place.String()), strings.ToLower(q.place)) {
+		return false
+	}
+	if q.minSize > 0 && uint64(r.Size) < q.minSize {
+		return false
+	}
+	if q.maxSize > 0 && uint64(r.Size) > q.maxSize {
+		return false
+	}
+	if q.dateSources != nil && !q.dateSources[r.DateSource] {
+		return false
+	}
+	duration := time.Duration(r.Video.Duration * float64(time.Second))
+	if q.minDuration > 0 && duration < q.minDuration {
+		return false
+	}
+	if q.maxDuration > 0 && (duration == 0 || duration > q.maxDuration) {
+		return false
+	}
+	if q.minLines > 0 && min(r.Camera.Width, r.Camera.Height) < q.minLines {
+		return false
+	}
+	if q.codecs != nil && !q.codecs[strings.ToLower(r.Video.Codec)] {
+		return false
+	}
+	if q.located && r.GPS == nil {
+		return false
+	}
+	return true
+}
+
+// sqlWhere returns a catalog condition, over the files table aliased f, and
+// its arguments, that selects at least the records q matches. It narrows
+// the rows read, using the capture_date and size indexes; match still
+// decides, so filters SQL can't express exactly (e.g. Unicode case folding)
+// stay correct.
+func (q *manifestQuery) sqlWhere() (string, []any) {
+	conds := []string{"1"}
+	var args []any
+	add := func(cond string, values ...any) {
+		conds = append(conds, cond)
+		args = append(args, values...)
+	}
+	in := func(column string, set map[string]bool) {
+		var marks []string
+		for v := range set {
+			marks = append(marks, "?")
+			args = append(args, v)
+		}
+		conds = append(conds, fmt.Sprintf("%s IN (%s)", column, strings.Join(marks, ", ")))
+	}
+
+	if !q.from.IsZero() {
+		add("f.capture_date >= ?", q.from.Format(manifestTimeLayout))
+	}
+	if !q.to.IsZero() {
+		add("f.capture_date < ?", q.to.Format(manifestTimeLayout))
+	}
+	if q.extensions != nil {
+		in("lower(f.extension)", q.extensions)
+	}
+	if q.source != "" {
+		add("f.source_folder = ? COLLATE NOCASE", q.source)
+	}
+	if q.camera != "" {
+		add(`f.camera_make || ' ' || f.camera_model LIKE ? ESCAPE '\'`, likePattern(q.camera))
+	}
+	if q.place != "" && !strings.Contains(q.place, ",") {
+		// Without a comma the text lies within one part of the place name
+		p := likePattern(q.place)
+		add(`(f.place_city LIKE ? ESCAPE '\' OR f.place_region LIKE ? ESCAPE '\' OR f.place_country LIKE ? ESCAPE '\')`, p, p, p)
+	}
+	if q.minSize > 0 {
+		add("f.size >= ?", int64(q.minSize))
+	}
+	if q.maxSize > 0 {
+		add("f.size <= ?", int64(q.maxSize))
+	}
+	if q.dateSources != nil {
+		in("f.date_source", q.dateSources)
+	}
+	if q.minDuration > 0 {
+		add("f.duration_s >= ?", q.minDuration.Seconds())
+	}
+	if q.maxDuration > 0 {
+		add("f.duration_s > 0 AND f.duration_s <= ?", q.maxDuration.Seconds())
+	}
+	if q.minLines > 0 {
+		add("min(f.width_px, f.height_px) >= ?", q.minLines)
+	}
+	if q.codecs != nil {
+		in("lower(f.video_codec)", q.codecs)
+	}
+	if q.located {
+		add("f.gps_latitude IS NOT NULL AND f.gps_longitude IS NOT NULL")
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// likePattern returns a LIKE pattern, with \ as the escape character, that
+// matches strings containing s.
+func likePattern(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+	return "%" + s + "%"
+}
+
+// queryRecords returns the records of the manifest matching q, in path
+// order. With the SQLite catalog the filters run in SQL, so only matching
+// rows are read.
+func queryRecords(m manifestBackend, q *manifestQuery) ([]manifestRecord, error) {
+	var recs []manifestRecord
+	var err error
+	if c, ok := m.(*catalogManifest); ok {
+		recs, err = c.recordsWhere(q.sqlWhere())
+	} else {
+		recs, err = m.records()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []manifestRecord
+	for _, r := range recs {
+		if q.match(r) {
+			matched = append(matched, r)
+		}
+	}
+	return matched, nil
+}
+
+// parseResolution parses a --min-res value: the shorter side of the frame
+// in pixels, as a number, "1080p", or "4k" and "8k" for 2160 and 4320.
+func parseResolution(s string) (int, error) {
+	switch strings.ToLower(s) {
+	case "4k":
+		return 2160, nil
+	case "8k":
+		return 4320, nil
+	}
+	lines, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(s), "p"))
+	if err != nil || lines <= 0 {
+		return 0, fmt.Errorf("invalid resolution %q (expected e.g. 720p, 1080p, 4k or 2160)", s)
+	}
+	return lines, nil
+}
+
+// parseQueryDate parses a YYYY, YYYY-MM or YYYY-MM-DD date and returns the
+// period it names as [start, end).
+func parseQueryDate(s string) (start, end time.Time, err error) {
+	for _, p := range []struct {
+		layout string
+		years  int
+		months int
+		days   int
+	}{
+		{"2006-01-02", 0, 0, 1},
+		{"2006-01", 0, 1, 0},
+		{"2006", 1, 0, 0},
+	} {
+		if t, err := time.ParseInLocation(p.layout, s, time.Local); err == nil {
+			return t, t.AddDate(p.years, p.months, p.days), nil
+		}
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid date %q (expected YYYY, YYYY-MM or YYYY-MM-DD)", s)
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// querySortKeys compare two records for each --sort key.
+var querySortKeys = map[string]func(a, b manifestRecord) bool{
+	"date":     func(a, b manifestRecord) bool { return a.CaptureDate.Before(b.CaptureDate) },
+	"path":     func(a, b manifestRecord) bool { return a.RelativePath < b.RelativePath },
+	"name":     func(a, b manifestRecord) bool { return a.Filename < b.Filename },
+	"size":     func(a, b manifestRecord) bool { return a.Size < b.Size },
+	"duration": func(a, b manifestRecord) bool { return a.Video.Duration < b.Video.Duration },
+}
+
+// queryResult is one record in --format json output.
+type queryResult struct {
+	Path          string       `json:"path"`
+	Filename      string       `json:"filename"`
+	SourceFolder  string       `json:"source_folder"`
+	SourcePath    string       `json:"source_path,omitempty"`
+	Size          int64        `json:"size"`
+	Modified      string       `json:"modified"`
+	CaptureDate   string       `json:"capture_date,omitempty"`
+	DateSource    string       `json:"date_source,omitempty"`
+	Camera        cameraInfo   `json:"camera"`
+	GPS           *gpsPosition `json:"gps,omitempty"`
+	Place         placeName    `json:"place"`
+	Video         *videoInfo   `json:"video,omitempty"`
+	Hash          string       `json:"hash,omitempty"`
+	Extension     string       `json:"extension"`
+	OrganizedDate string       `json:"organized_date"`
+}
+
+// writeQueryJSON writes recs as a JSON array. Dates are RFC 3339 and paths
+// relative to the library root, with forward slashes.
+func writeQueryJSON(w io.Writer, recs []manifestRecord) error {
+	results := make([]queryResult, 0, len(recs))
+	for _, r := range recs {
+		res := queryResult{
+			Path:          filepath.ToSlash(r.RelativePath),
+			Filename:      r.Filename,
+			SourceFolder:  r.SourceFolder,
+			SourcePath:    r.SourcePath,
+			Size:          r.Size,
+			Modified:      r.Modified.Format(time.RFC3339),
+			DateSource:    r.DateSource,
+			Camera:        r.Camera,
+			GPS:           r.GPS,
+			Place:         r.Place,
+			Hash:          r.Hash,
+			Extension:     r.Extension,
+			OrganizedDate: r.OrganizedDate.Format(time.RFC3339),
+		}
+		if !r.CaptureDate.IsZero() {
+			res.CaptureDate = r.CaptureDate.Format(time.RFC3339)
+		}
+		if r.Video != (videoInfo{}) {
+			video := r.Video
+			res.Video = &video
+		}
+		results = append(results, res)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
+
+// writeQueryTable writes recs as an aligned table followed by a total,
+// including the running time of any videos.
+func writeQueryTable(w io.Writer, recs []manifestRecord) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CAPTURED\tSIZE\tCAMERA\tSOURCE\tPATH")
+	var total uint64
+	var running float64
+	for _, r := range recs {
+		captured := "-"
+		if !r.CaptureDate.IsZero() {
+			captured = r.CaptureDate.Format("2006-01-02 15:04")
+		}
+		camera := strings.TrimSpace(r.Camera.Make + " " + r.Camera.Model)
+		if camera == "" {
+			camera = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", captured, formatSize(uint64(r.Size)), camera, r.SourceFolder, r.RelativePath)
+		total += uint64(r.Size)
+		running += r.Video.Duration
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	summary := fmt.Sprintf("%d files, %s", len(recs), formatSize(total))
+	if running > 0 {
+		summary += fmt.Sprintf(", %s of video", time.Duration(running*float64(time.Second)).Round(time.Second))
+	}
+	_, err := fmt.Fprintln(w, "\n"+summary)
+	return err
+}
+
+// runQuery implements the query subcommand: list the manifest records that
+// match the given filters, sorted, as a table, CSV, JSON or a list of
+// absolute paths.
+func runQuery(args []string) int {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	rootDir := fs.String("root", "", "Photo library root directory (default: current directory)")
+	from := fs.String("from", "", "Captured on or after this date: YYYY, YYYY-MM or YYYY-MM-DD")
+	to := fs.String("to", "", "Captured on or before this date (the whole year, month or day)")
+	ext := fs.String("ext", "", "Extensions, comma-separated (e.g. nef,dng)")
+	source := fs.String("source", "", "Incoming folder the files were organized from (source_folder)")
+	camera := fs.String("camera", "", "Camera make or model, or part of it (e.g. \"nikon z6\")")
+	place := fs.String("place", "", "City, region or country where the photos were taken, or part of it")
+	minSize := fs.String("min-size", "", "Minimum file size (e.g. 10MB)")
+	maxSize := fs.String("max-size", "", "Maximum file size (e.g. 2GB)")
+	minDuration := fs.Duration("min-duration", 0, "Videos at least this long (e.g. 2m)")
+	maxDuration := fs.Duration("max-duration", 0, "Videos at most this long (e.g. 30s)")
+	minRes := fs.String("min-res", "", "Minimum resolution, by the shorter side of the frame: 720p, 1080p, 4k or pixels")
+	codec := fs.String("codec", "", "Video codecs, comma-separated FourCCs (e.g. hvc1,avc1)")
+	dateSource := fs.String("date-source", "", "Where the capture date came from, comma-separated (exif, filename, mtime, ...)")
+	sortKey := fs.String("sort", "date", "Sort by date, path, name, size or duration")
+	reverse := fs.Bool("reverse", false, "Reverse the sort order")
+	limit := fs.Int("limit", 0, "Show at most this many files (0 for all)")
+	format := fs.String("format", "table", "Output format: table, csv, json or paths")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "List manifest entries matching filters\n\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "  %s query [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s query --ext nef --from 2024-06 --to 2024-06\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s query --source Dad-phone --format paths | xargs ls -l\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s query --source Drone --min-res 4k --min-duration 2m\n", os.Args[0])
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	usageError := func(err error) int {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return exitUsage
+	}
+
+	q := &manifestQuery{
+		source: strings.TrimPrefix(filepath.ToSlash(*source), "Incoming/"),
+		camera: *camera,
+		place:  *place,
+	}
+	var err error
+	if *from != "" {
+		if q.from, _, err = parseQueryDate(*from); err != nil {
+			return usageError(err)
+		}
+	}
+	if *to != "" {
+		if _, q.to, err = parseQueryDate(*to); err != nil {
+			return usageError(err)
+		}
+	}
+	if exts := splitList(*ext); len(exts) > 0 {
+		q.extensions = make(map[string]bool)
+		for _, e := range exts {
+			q.extensions["."+strings.ToLower(strings.TrimPrefix(e, "."))] = true
+		}
+	}
+	if *minSize != "" {
+		if q.minSize, err = parseSize(*minSize); err != nil {
+			return usageError(err)
+		}
+	}
+	if *maxSize != "" {
+		if q.maxSize, err = parseSize(*maxSize); err != nil {
+			return usageError(err)
+		}
+	}
+	if sources := splitList(*dateSource); len(sources) > 0 {
+		q.dateSources = make(map[string]bool)
+		for _, s := range sources {
+			q.dateSources[strings.ToLower(s)] = true
+		}
+	}
+	q.minDuration, q.maxDuration = *minDuration, *maxDuration
+	if *minRes != "" {
+		if q.minLines, err = parseResolution(*minRes); err != nil {
+			return usageError(err)
+		}
+	}
+	if codecs := splitList(*codec); len(codecs) > 0 {
+		q.codecs = make(map[string]bool)
+		for _, c := range codecs {
+			q.codecs[strings.ToLower(c)] = true
+		}
+	}
+	less, ok := querySortKeys[*sortKey]
+	if !ok {
+		return usageError(fmt.Errorf("unknown sort key %q (expected date, path, name, size or duration)", *sortKey))
+	}
+	switch *format {
+	case "table", "csv", "json", "paths":
+	default:
+		return usageError(fmt.Errorf("unknown format %q (expected table, csv, json or paths)", *format))
+	}
+
+	if err := setLibraryPaths(*rootDir); err != nil {
+		fmt.Fprintln(os.Stderr, "Error getting current directory:", err)
+		return exitFatal
+	}
+
+	manifest, err := openManifest()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error opening manifest:", err)
+		return exitFatal
+	}
+	matched, err := queryRecords(manifest, q)
+	manifest.close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading manifest:", err)
+		return exitFatal
+	}
+
+	// Ties keep path order, so output is stable
+	sort.SliceStable(matched, func(i, j int) bool {
+		if *reverse {
+			return less(matched[j], matched[i])
+		}
+		return less(matched[i], matched[j])
+	})
+	if *limit > 0 && len(matched) > *limit {
+		matched = matched[:*limit]
+	}
+
+	switch *format {
+	case "csv":
+		err = writeRecordsCSV(os.Stdout, matched)
+	case "json":
+		err = writeQueryJSON(os.Stdout, matched)
+	case "paths":
+		for _, r := range matched {
+			if _, err = fmt.Println(filepath.Join(photoRoot, r.RelativePath)); err != nil {
+				break
+			}
+		}
+	default:
+		err = writeQueryTable(os.Stdout, matched)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing results:", err)
+		return exitFatal
+	}
+	return exitOK
+}
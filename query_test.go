@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// queryTestRecords returns records that differ in every filtered field.
+func queryTestRecords() []manifestRecord {
+	photo := testManifestRecord("2024/2024-06-01/IMG_0001.JPG")
+
+	raw := testManifestRecord("2024/2024-06-15/DSC_0002.NEF")
+	raw.Extension, raw.SourceFolder, raw.Size = ".NEF", "Nikon_Z6", 40<<20
+	raw.Camera = cameraInfo{Make: "NIKON CORPORATION", Model: "NIKON Z 6", Width: 6048, Height: 4024}
+	raw.CaptureDate = time.Date(2024, 6, 15, 18, 30, 0, 0, time.Local)
+	raw.GPS, raw.Place = nil, placeName{}
+
+	clip := testManifestRecord("2024/2024-07-04/DJI_0003.MP4")
+	clip.Extension, clip.SourceFolder, clip.Size = ".mp4", "Drone", 900<<20
+	clip.Camera = cameraInfo{Make: "DJI", Model: "Mavic 3", Width: 3840, Height: 2160}
+	clip.Video = videoInfo{Duration: 150, FrameRate: 29.97, Codec: "hvc1"}
+	clip.CaptureDate = time.Date(2024, 7, 4, 9, 0, 0, 0, time.Local)
+	clip.DateSource = dateSourceMtime
+	clip.Place = placeName{City: "Lisbon", Country: "Portugal"}
+
+	return []manifestRecord{photo, raw, clip}
+}
+
+func TestQueryRecordsCatalog(t *testing.T) {
+	newTestLibrary(t)
+	if err := os.MkdirAll(manifestDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	recs := queryTestRecords()
+
+	csv := &csvManifest{path: manifestFile}
+	if _, err := csv.add(recs); err != nil {
+		t.Fatal(err)
+	}
+	catalog, err := openCatalog(catalogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer catalog.close()
+	if _, err := catalog.add(recs); err != nil {
+		t.Fatal(err)
+	}
+
+	from, _, _ := parseQueryDate("2024-06-10")
+	_, to, _ := parseQueryDate("2024-06")
+	tests := []struct {
+		name string
+		q    manifestQuery
+		want []string // Relative paths, in path order
+	}{
+		{"everything", manifestQuery{}, []string{recs[0].RelativePath, recs[1].RelativePath, recs[2].RelativePath}},
+		{"date range", manifestQuery{from: from, to: to}, []string{recs[1].RelativePath}},
+		{"extension", manifestQuery{extensions: map[string]bool{".nef": true}}, []string{recs[1].RelativePath}},
+		{"source", manifestQuery{source: "drone"}, []string{recs[2].RelativePath}},
+		{"camera", manifestQuery{camera: "nikon z"}, []string{recs[1].RelativePath}},
+		{"camera with LIKE wildcard", manifestQuery{camera: "c_3"}, nil},
+		{"camera with LIKE escape", manifestQuery{camera: `dji\`}, nil},
+		{"place", manifestQuery{place: "paris"}, []string{recs[0].RelativePath}},
+		{"place across parts", manifestQuery{place: "lisbon, portugal"}, []string{recs[2].RelativePath}},
+		{"size", manifestQuery{minSize: 1 << 20, maxSize: 100 << 20}, []string{recs[1].RelativePath}},
+		{"date source", manifestQuery{dateSources: map[string]bool{dateSourceMtime: true}}, []string{recs[2].RelativePath}},
+		{"duration", manifestQuery{minDuration: 2 * time.Minute, maxDuration: 3 * time.Minute}, []string{recs[2].RelativePath}},
+		{"max duration skips photos", manifestQuery{maxDuration: time.Hour}, []string{recs[2].RelativePath}},
+		{"resolution", manifestQuery{minLines: 2160}, []string{recs[1].RelativePath, recs[2].RelativePath}},
+		{"codec", manifestQuery{codecs: map[string]bool{"hvc1": true}}, []string{recs[2].RelativePath}},
+		{"located", manifestQuery{located: true}, []string{recs[0].RelativePath, recs[2].RelativePath}},
+	}
+
+	paths := func(recs []manifestRecord) []string {
+		var p []string
+		for _, r := range recs {
+			p = append(p, r.RelativePath)
+		}
+		return p
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fromCSV, err := queryRecords(csv, &tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fromCatalog, err := queryRecords(catalog, &tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := paths(fromCSV); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CSV manifest: got %q, want %q", got, tt.want)
+			}
+			if got := paths(fromCatalog); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("catalog: got %q, want %q", got, tt.want)
+			}
+
+			// The filters run in SQL, not only in match
+			selected, err := catalog.recordsWhere(tt.q.sqlWhere())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.name != "place across parts" && len(selected) != len(tt.want) {
+				t.Errorf("SQL selected %q, want %q", paths(selected), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// ficlone is the FICLONE ioctl request number from <linux/fs.h>.
+const ficlone = 0x40049409
+
+// cloneFile makes dst share src's data blocks using the FICLONE ioctl.
+// Supported on Btrfs, XFS (reflink=1), bcachefs and similar filesystems.
+func cloneFile(dst, src *os.File) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dst.Fd(), ficlone, src.Fd())
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// cloneFile is not supported on this platform; callers fall back to a copy.
+func cloneFile(dst, src *os.File) error {
+	return errors.New("reflink not supported on this platform")
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// =============================================================================
+// Re-indexing Originals
+// =============================================================================
+
+// reindexRecord merges what was just read from a file in Originals/ into its
+// existing manifest entry, if it has one. Fields only known when the file
+// was organized (where it came from and when) are kept, as are a date set by
+// hand during review or better than the file's modification time, and a
+// position the file doesn't record (e.g. from geotag); everything else is
+// taken from the file. Files without an entry are adopted.
+func reindexRecord(m fileMeta, existing manifestRecord, found bool) manifestRecord {
+	r := adoptedRecord(m)
+	if !found {
+		return r
+	}
+
+	r.SourceFolder = existing.SourceFolder
+	r.OrganizedDate = existing.OrganizedDate
+	r.SourcePath = existing.SourcePath
+	guessed := func(source string) bool {
+		return source == "" || source == dateSourceMtime || source == dateSourceNow
+	}
+	if existing.DateSource == dateSourceManual || (guessed(r.DateSource) && !guessed(existing.DateSource)) {
+		r.CaptureDate, r.DateSource = existing.CaptureDate, existing.DateSource
+	}
+	if r.GPS == nil && existing.GPS != nil {
+		r.GPS, r.Place = existing.GPS, existing.Place
+	}
+	return r
+}
+
+// sameRecord reports whether a and b would be written the same way.
+func sameRecord(a, b manifestRecord) bool {
+	fa, fb := a.fields(), b.fields()
+	for k, v := range fa {
+		if fb[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// runReindex implements the reindex subcommand: read every media file in
+// Originals/ with the same extractors as organize and merge the results
+// into the manifest, adding files organized without -m. Previews by
+// default; -x writes the manifest. Entries whose file is gone are left
+// alone (see fsck).
+func runReindex(args []string) int {
+	fs := flag.NewFlagSet("reindex", flag.ExitOnError)
+	rootDir := fs.String("root", "", "Photo library root directory (default: current directory)")
+	execute := fs.Bool("execute", false, "Write the merged manifest (default is to preview)")
+	executeShort := fs.Bool("x", false, "Write the merged manifest (short for --execute)")
+	noCache := fs.Bool("no-cache", false, "Don't read or update the metadata cache")
+	fs.IntVar(&jobs, "jobs", jobs, "Number of files to read in parallel")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Rebuild the manifest from the files in Originals\n\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "  %s reindex [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	dryRun := !*execute && !*executeShort
+
+	if err := setLibraryPaths(*rootDir); err != nil {
+		fmt.Println("Error getting current directory:", err)
+		return exitFatal
+	}
+
+	if !dryRun {
+		lock, err := acquireLibraryLock(0)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return exitFatal
+		}
+		defer lock.release()
+	}
+
+	if !*noCache {
+		metaCache = loadMetadataCache(cacheFile)
+		defer saveMetadataCache()
+	}
+
+	manifest, err := openManifest()
+	if err != nil {
+		fmt.Println("Error opening manifest:", err)
+		return exitFatal
+	}
+	defer manifest.close()
+
+	recs, err := manifest.records()
+	if err != nil {
+		fmt.Println("Error reading manifest:", err)
+		return exitFatal
+	}
+	byPath := make(map[string]manifestRecord, len(recs))
+	for _, r := range recs {
+		byPath[r.RelativePath] = r
+	}
+
+	files, err := findMediaFiles(originalsDir)
+	if err != nil {
+		fmt.Println("Error scanning library:", err)
+		return exitFatal
+	}
+
+	if dryRun {
+		fmt.Println("=== DRY RUN MODE (use -x to write the manifest) ===")
+	}
+	fmt.Printf("Re-indexing %d files in %s\n\n", len(files), originalsDir)
+
+	var changes []manifestRecord
+	seen := make(map[string]bool)
+	added, updated, unchanged, failed := 0, 0, 0, 0
+	for _, m := range extractMetadata(originalsDir, files, nil) {
+		seen[displayPath(m.Path)] = true
+		if m.Err != nil {
+			fmt.Printf("  ✗ %s (cannot read: %v)\n", displayPath(m.Path), m.Err)
+			failed++
+			continue
+		}
+
+		existing, found := byPath[displayPath(m.Path)]
+		r := reindexRecord(m, existing, found)
+		switch {
+		case !found:
+			fmt.Printf("  + %s\n", r.RelativePath)
+			added++
+		case !sameRecord(r, existing):
+			fmt.Printf("  ~ %s\n", r.RelativePath)
+			updated++
+		default:
+			unchanged++
+			continue
+		}
+		changes = append(changes, r)
+	}
+
+	if len(changes) > 0 || failed > 0 {
+		fmt.Println()
+	}
+	fmt.Printf("Adopted:   %d new entries\n", added)
+	fmt.Printf("Updated:   %d entries\n", updated)
+	fmt.Printf("Unchanged: %d entries\n", unchanged)
+	if failed > 0 {
+		fmt.Printf("Failed:    %d files\n", failed)
+	}
+	gone := 0
+	for _, r := range recs {
+		if !seen[r.RelativePath] {
+			gone++
+		}
+	}
+	if gone > 0 {
+		fmt.Printf("\n%d manifest entries have no file in %s; run fsck to list them\n", gone, originalsDir)
+	}
+
+	if !dryRun && len(changes) > 0 {
+		if err := manifest.update(changes); err != nil {
+			fmt.Println("Error updating manifest:", err)
+			return exitFatal
+		}
+		fmt.Printf("\nManifest updated with %d entries\n", len(changes))
+	}
+
+	if failed > 0 {
+		return exitPartial
+	}
+	return exitOK
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// =============================================================================
+// Exit Codes and Error Report
+// =============================================================================
+
+// Process exit codes.
+const (
+	exitOK          = 0 // Everything planned was done
+	exitFatal       = 1 // The run stopped early (bad setup, lock busy, no space, ...)
+	exitUsage       = 2 // Invalid command line
+	exitPartial     = 3 // The run finished, but some files or steps failed
+	exitNothingToDo = 4 // The run finished and there was nothing to transfer
+)
+
+var (
+	failuresMu sync.Mutex
+	failures   []fileError // Failed operations of this run, in order
+)
+
+// recordFailure adds a failed operation to the run's error report and emits
+// an error event. The caller prints its own message. Safe for concurrent use.
+func recordFailure(path, op string, err error) {
+	failuresMu.Lock()
+	failures = append(failures, fileError{Path: path, Op: op, Err: err})
+	failuresMu.Unlock()
+
+	emitEvent(outputEvent{Event: eventError, Source: libraryRelPath(path), Op: op, Error: err.Error()})
+}
+
+// finishRun reports the run's failures, if any, on the console and in
+// _Manifest/logs/, along with the number of files dated from their
+// modification time, and returns the exit code for a run that was not stopped
+// by a fatal error.
+func finishRun() int {
+	failuresMu.Lock()
+	defer failuresMu.Unlock()
+
+	if n := dateFallbacks.Load(); n > 0 {
+		logf(levelWarn, "\nWarning: %d files had no EXIF or filename date and were dated from their modification time (see the run log)\n", n)
+	}
+
+	if len(failures) == 0 {
+		eventMu.Lock()
+		transfers := eventCounts[eventPlanned] + eventCounts[eventMoved]
+		eventMu.Unlock()
+		if transfers == 0 {
+			return exitNothingToDo
+		}
+		return exitOK
+	}
+
+	var table strings.Builder
+	tw := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  OPERATION\tFILE\tERROR")
+	for _, f := range failures {
+		fmt.Fprintf(tw, "  %s\t%s\t%v\n", f.Op, libraryRelPath(f.Path), f.Err)
+	}
+	tw.Flush()
+	logf(levelError, "\n%d operations failed:\n\n%s", len(failures), table.String())
+
+	if path, err := saveErrorReport(); err != nil {
+		logf(levelWarn, "Warning: could not save error report: %v\n", err)
+	} else {
+		logf(levelInfo, "\nError report saved to %s\n", displayPath(path))
+	}
+	return exitPartial
+}
+
+// saveErrorReport writes the failures to _Manifest/logs/errors-<run-id>.csv
+// and returns the file's path. Called with failuresMu held.
+func saveErrorReport() (string, error) {
+	if err := os.MkdirAll(logsDir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(logsDir, "errors-"+runID+".csv")
+
+	return path, writeFileAtomic(path, 0644, func(w io.Writer) error {
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"operation", "path", "error"})
+		for _, f := range failures {
+			writer.Write([]string{f.Op, libraryRelPath(f.Path), f.Err.Error()})
+		}
+		writer.Flush()
+		return writer.Error()
+	})
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =============================================================================
+// Interactive Plan Review
+// =============================================================================
+
+// reviewColors are ANSI escapes used by the review screen when stdout is a
+// terminal; empty otherwise so piped output stays plain.
+var reviewColors = struct{ warn, dim, bold, reset string }{}
+
+// reviewGroup is one destination day folder in the review.
+type reviewGroup struct {
+	folder string // Day folder relative to Originals/ (e.g. 2024/2024-06-01 Beach)
+	files  []int  // Indexes into the reviewed metas
+}
+
+// reviewState tracks the edits made during a review.
+type reviewState struct {
+	metas    []fileMeta
+	excluded map[int]bool
+	plan     map[int]planEntry // Plan decision for each included file
+	groups   []reviewGroup
+}
+
+// reviewPlan lets the user review the plan for metas before anything is
+// executed: files are grouped by destination day and low-confidence dates,
+// renames and duplicates are flagged. Files can be excluded, and a group can
+// get a different date or an event label. On a terminal this is the
+// full-screen review (see reviewScreen); otherwise commands are read line by
+// line from in. Returns the edited metas, and false if the user aborted the
+// run.
+func reviewPlan(metas []fileMeta, in io.Reader) ([]fileMeta, bool) {
+	s := &reviewState{
+		metas:    append([]fileMeta(nil), metas...),
+		excluded: make(map[int]bool),
+	}
+	s.rebuild()
+
+	if f, ok := in.(*os.File); ok && isTerminal(f) && isTerminal(os.Stdout) {
+		if kept, accepted, err := runReviewScreen(s, f, os.Stdout); err == nil {
+			return kept, accepted
+		}
+		// No raw mode on this terminal; review line by line
+	}
+	return reviewLines(s, in)
+}
+
+// isTerminal reports whether f is a terminal (character device).
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// reviewLines is the line-oriented review, for input that is not a
+// terminal: commands are read line by line from in.
+func reviewLines(s *reviewState, in io.Reader) ([]fileMeta, bool) {
+	if isTerminal(os.Stdout) {
+		reviewColors.warn, reviewColors.dim, reviewColors.bold, reviewColors.reset = "\033[33m", "\033[2m", "\033[1m", "\033[0m"
+	}
+	s.printSummary()
+	printReviewHelp()
+
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Print("review> ")
+		if !scanner.Scan() {
+			fmt.Println()
+			return nil, false
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			s.printSummary()
+			continue
+		}
+
+		switch cmd := fields[0]; {
+		case cmd == "go":
+			return s.accept(), true
+		case cmd == "q" || cmd == "quit":
+			return nil, false
+		case cmd == "?" || cmd == "h" || cmd == "help":
+			printReviewHelp()
+		case cmd == "x" && len(fields) == 2:
+			s.toggleExclude(fields[1])
+		case cmd == "d" && len(fields) == 3:
+			s.setDate(fields[1], fields[2])
+		case cmd == "l" && len(fields) >= 3:
+			s.setLabel(fields[1], strings.Join(fields[2:], " "))
+		default:
+			if g, ok := s.group(cmd); ok {
+				s.printGroup(g)
+			} else {
+				fmt.Printf("Unknown command %q (? for help)\n", scanner.Text())
+			}
+		}
+	}
+}
+
+// printReviewHelp lists the review commands.
+func printReviewHelp() {
+	fmt.Println("Commands:")
+	fmt.Println("  <n>                 Show the files in group n")
+	fmt.Println("  x <n> | x <n>.<m>   Exclude (or re-include) group n, or file m of group n")
+	fmt.Println("  d <n> YYYY-MM-DD    Move group n to another date")
+	fmt.Println("  l <n> <label>       Set the event label of group n (- clears it)")
+	fmt.Println("  <enter>             Show the groups again")
+	fmt.Println("  go                  Accept the plan and continue")
+	fmt.Println("  q                   Abort without changing anything")
+	fmt.Println()
+}
+
+// rebuild re-plans the included files and regroups every file by its
+// destination day folder. Excluded files stay listed so they can be
+// re-included; files already imported or unreadable are left out.
+func (s *reviewState) rebuild() {
+	var included []fileMeta
+	var indexes []int
+	byFolder := make(map[string][]int)
+	for i, m := range s.metas {
+		if m.Err != nil || m.Imported {
+			continue
+		}
+		folder, _ := filepath.Rel(originalsDir, filepath.Dir(getDestination(m)))
+		byFolder[folder] = append(byFolder[folder], i)
+		if !s.excluded[i] {
+			included = append(included, m)
+			indexes = append(indexes, i)
+		}
+	}
+
+	// buildPlan yields one entry per readable file, in order
+	s.plan = make(map[int]planEntry)
+	for k, e := range buildPlan(included) {
+		s.plan[indexes[k]] = e
+	}
+
+	s.groups = s.groups[:0]
+	for folder, files := range byFolder {
+		s.groups = append(s.groups, reviewGroup{folder: filepath.ToSlash(folder), files: files})
+	}
+	sort.Slice(s.groups, func(a, b int) bool { return s.groups[a].folder < s.groups[b].folder })
+}
+
+// accept returns the metas that were not excluded, noting how many were.
+func (s *reviewState) accept() []fileMeta {
+	var kept []fileMeta
+	for i, m := range s.metas {
+		if !s.excluded[i] {
+			kept = append(kept, m)
+		}
+	}
+	if n := len(s.excluded); n > 0 {
+		fmt.Printf("Excluded %d files from this run\n", n)
+	}
+	fmt.Println()
+	return kept
+}
+
+// counts returns the number of files shown in the review, and of those left
+// out of it because they were already imported or could not be read.
+func (s *reviewState) counts() (shown, imported, unreadable int) {
+	for _, m := range s.metas {
+		if m.Err != nil {
+			unreadable++
+		} else if m.Imported {
+			imported++
+		}
+	}
+	return len(s.metas) - imported - unreadable, imported, unreadable
+}
+
+// flags returns the review markers for file i:
+// ! low-confidence date, ~ renamed on collision, = duplicate, x excluded.
+func (s *reviewState) flags(i int) string {
+	if s.excluded[i] {
+		return "x"
+	}
+	flags := ""
+	if ds := s.metas[i].DateSource; ds == dateSourceMtime || ds == dateSourceNow {
+		flags += "!"
+	}
+	if e := s.plan[i]; e.Renamed {
+		flags += "~"
+	} else if e.Action == actionDuplicate {
+		flags += "="
+	}
+	return flags
+}
+
+// groupNotes summarizes the warnings of group g, e.g. "! 3 guessed dates",
+// or returns "" if there are none.
+func (s *reviewState) groupNotes(g int) string {
+	guessed, renamed, duplicates, excluded := 0, 0, 0, 0
+	for _, i := range s.groups[g].files {
+		f := s.flags(i)
+		switch {
+		case f == "x":
+			excluded++
+			continue
+		case strings.Contains(f, "~"):
+			renamed++
+		case strings.Contains(f, "="):
+			duplicates++
+		}
+		if strings.Contains(f, "!") {
+			guessed++
+		}
+	}
+
+	var notes []string
+	if guessed > 0 {
+		notes = append(notes, fmt.Sprintf("! %d guessed dates", guessed))
+	}
+	if renamed > 0 {
+		notes = append(notes, fmt.Sprintf("~ %d renamed", renamed))
+	}
+	if duplicates > 0 {
+		notes = append(notes, fmt.Sprintf("= %d duplicates", duplicates))
+	}
+	if excluded > 0 {
+		notes = append(notes, fmt.Sprintf("x %d excluded", excluded))
+	}
+	return strings.Join(notes, "  ")
+}
+
+// fileLine describes file i of the review: its name, the name it is
+// renamed to on a collision, and its date and date source.
+func (s *reviewState) fileLine(i int) string {
+	m := s.metas[i]
+	name := filepath.Base(m.Path)
+	if e := s.plan[i]; e.Renamed {
+		name += " → " + filepath.Base(e.DestPath)
+	}
+	return fmt.Sprintf("%s  %s (%s)", name, m.CaptureDate.Format("2006-01-02 15:04:05"), m.DateSource)
+}
+
+// printSummary lists the groups with their counts and warnings.
+func (s *reviewState) printSummary() {
+	shown, imported, unreadable := s.counts()
+	fmt.Printf("\n%sPlan review: %d files in %d day folders, %d excluded%s\n",
+		reviewColors.bold, shown, len(s.groups), len(s.excluded), reviewColors.reset)
+	if imported > 0 || unreadable > 0 {
+		fmt.Printf("%s(%d already imported and %d unreadable files not shown)%s\n", reviewColors.dim, imported, unreadable, reviewColors.reset)
+	}
+	fmt.Println()
+
+	for n, g := range s.groups {
+		note := ""
+		if notes := s.groupNotes(n); notes != "" {
+			note = "  " + reviewColors.warn + notes + reviewColors.reset
+		}
+		fmt.Printf("  [%d] %-32s %5d files%s\n", n+1, g.folder, len(g.files), note)
+	}
+	fmt.Println()
+}
+
+// printGroup lists the files in group g with their date and markers.
+func (s *reviewState) printGroup(g int) {
+	group := s.groups[g]
+	fmt.Printf("\n%s[%d] %s%s\n", reviewColors.bold, g+1, group.folder, reviewColors.reset)
+	for k, i := range group.files {
+		f := s.flags(i)
+		color := ""
+		switch {
+		case f == "x":
+			color = reviewColors.dim
+		case f != "":
+			color = reviewColors.warn
+		}
+		fmt.Printf("%s  %-8s %-2s %s%s\n", color, fmt.Sprintf("%d.%d", g+1, k+1), f, s.fileLine(i), reviewColors.reset)
+	}
+	fmt.Println()
+}
+
+// group parses a 1-based group number.
+func (s *reviewState) group(arg string) (int, bool) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 || n > len(s.groups) {
+		return 0, false
+	}
+	return n - 1, true
+}
+
+// toggleExclude excludes (or re-includes) a group "n" or a file "n.m".
+// A group is re-included only if all of its files were excluded.
+func (s *reviewState) toggleExclude(arg string) {
+	groupArg, fileArg, isFile := strings.Cut(arg, ".")
+	g, ok := s.group(groupArg)
+	if !ok {
+		fmt.Printf("No group %s\n", groupArg)
+		return
+	}
+	files := s.groups[g].files
+
+	if isFile {
+		k, err := strconv.Atoi(fileArg)
+		if err != nil || k < 1 || k > len(files) {
+			fmt.Printf("No file %s\n", arg)
+			return
+		}
+		files = files[k-1 : k]
+	}
+
+	s.exclude(files)
+	if isFile {
+		s.printGroup(g)
+	} else {
+		s.printSummary()
+	}
+}
+
+// exclude excludes files, or re-includes them if all of them already were.
+func (s *reviewState) exclude(files []int) {
+	exclude := false
+	for _, i := range files {
+		if !s.excluded[i] {
+			exclude = true
+		}
+	}
+	for _, i := range files {
+		if exclude {
+			s.excluded[i] = true
+		} else {
+			delete(s.excluded, i)
+		}
+	}
+	s.rebuild()
+}
+
+// setDate moves every file in a group to another day, keeping each file's
+// time of day.
+func (s *reviewState) setDate(groupArg, dateArg string) {
+	g, ok := s.group(groupArg)
+	if !ok {
+		fmt.Printf("No group %s\n", groupArg)
+		return
+	}
+	if err := s.moveGroup(g, dateArg); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	s.printSummary()
+}
+
+// moveGroup moves every file in group g to the day given as YYYY-MM-DD,
+// keeping each file's time of day. The new date is recorded as set
+// manually.
+func (s *reviewState) moveGroup(g int, date string) error {
+	day, err := time.ParseInLocation("2006-01-02", date, time.Local)
+	if err != nil {
+		return fmt.Errorf("invalid date %q (expected YYYY-MM-DD)", date)
+	}
+
+	for _, i := range s.groups[g].files {
+		m := &s.metas[i]
+		t := m.CaptureDate.In(time.Local)
+		m.CaptureDate = time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+		m.DateSource = dateSourceManual
+	}
+	s.rebuild()
+	return nil
+}
+
+// setLabel sets the event label of every file in a group; "-" clears it.
+func (s *reviewState) setLabel(groupArg, label string) {
+	g, ok := s.group(groupArg)
+	if !ok {
+		fmt.Printf("No group %s\n", groupArg)
+		return
+	}
+	if err := s.labelGroup(g, label); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	s.printSummary()
+}
+
+// labelGroup sets the event label of every file in group g; "-" or ""
+// clears it.
+func (s *reviewState) labelGroup(g int, label string) error {
+	label = strings.TrimSpace(label)
+	if label == "-" {
+		label = ""
+	}
+	if strings.ContainsAny(label, `/\:`) || strings.HasPrefix(label, ".") {
+		return fmt.Errorf("invalid label %q: it becomes part of a folder name", label)
+	}
+
+	for _, i := range s.groups[g].files {
+		s.metas[i].EventLabel = label
+	}
+	s.rebuild()
+	return nil
+}
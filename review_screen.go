@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// =============================================================================
+// Full-Screen Plan Review
+// =============================================================================
+
+// reviewRow is one line of the review list: a day folder, or one of its
+// files while the folder is open.
+type reviewRow struct {
+	group int // Index into the review's groups
+	file  int // Index into the group's files; -1 for the folder itself
+}
+
+// reviewScreen is the review shown on a terminal: the plan's day folders as
+// a list to move through, each opening to show its files. Keys exclude the
+// selected file or folder, and move or label the selected folder.
+type reviewScreen struct {
+	*reviewState
+	open   map[string]bool          // Open folders
+	cursor int                      // Selected row
+	top    int                      // First row on screen
+	page   int                      // Rows on screen, for page up and down
+	prompt string                   // Question on the status line; "" if none
+	input  []rune                   // Answer typed so far
+	answer func(input string) error // Applies the answer
+	status string                   // Result of the last key
+	help   bool                     // Show the key help instead of the list
+}
+
+// reviewScreenHelp describes the keys of the review screen.
+var reviewScreenHelp = []string{
+	"↑ ↓ / k j        Move through the list",
+	"PgUp PgDn        Move a screen at a time",
+	"Home End / g G   Go to the first or last folder",
+	"Enter            Open or close the selected folder",
+	"→ ← / l h        Open or close the selected folder",
+	"Space / x        Exclude (or re-include) the selected file or folder",
+	"d                Move the selected folder to another date",
+	"e                Set the event label of the selected folder",
+	"a                Accept the plan and continue",
+	"q / Ctrl-C       Abort without changing anything",
+	"",
+	"Markers: ! guessed date  ~ renamed on a collision  = duplicate  x excluded",
+}
+
+// reviewScreenKeys is the key summary at the bottom of the screen.
+const reviewScreenKeys = "↑↓ move  enter open  space exclude  d date  e label  a accept  q quit  ? help"
+
+// Styles used by the review screen.
+const (
+	styleReset    = "\033[0m"
+	styleBold     = "\033[1m"
+	styleDim      = "\033[2m"
+	styleWarn     = "\033[33m"
+	styleSelected = "\033[7m"
+)
+
+// runReviewScreen runs the full-screen review of s on the terminal in and
+// out. Returns the metas to organize, and false if the user aborted; an
+// error means the terminal could not be put in raw mode and nothing was
+// shown.
+func runReviewScreen(s *reviewState, in, out *os.File) ([]fileMeta, bool, error) {
+	restore, err := makeRawTerminal(in, out)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Alternate screen, so the scrollback is left as it was, and no cursor
+	fmt.Fprint(out, "\033[?1049h\033[?25l")
+	v := &reviewScreen{reviewState: s, open: make(map[string]bool)}
+	keys := bufio.NewReader(in)
+	accepted := false
+	for {
+		width, height := terminalSize(out)
+		fmt.Fprint(out, "\033[H"+v.render(width, height)+"\033[J")
+
+		key, err := readKey(keys)
+		if err != nil {
+			break // Input closed; abort
+		}
+		var done bool
+		if done, accepted = v.handleKey(key); done {
+			break
+		}
+	}
+	fmt.Fprint(out, "\033[?25h\033[?1049l")
+	restore()
+
+	if !accepted {
+		return nil, false, nil
+	}
+	return s.accept(), true, nil
+}
+
+// reviewEscapeKeys names the keys sent as ESC [ or ESC O sequences, by the
+// bytes after the bracket.
+var reviewEscapeKeys = map[string]string{
+	"A": "up", "B": "down", "C": "right", "D": "left",
+	"H": "home", "F": "end", "1~": "home", "4~": "end", "7~": "home", "8~": "end",
+	"5~": "pgup", "6~": "pgdown",
+}
+
+// readKey reads one key press from a terminal in raw mode. Special keys are
+// returned by name ("up", "enter", "ctrl-c", ...), others as the character
+// typed; unknown sequences as "".
+func readKey(r *bufio.Reader) (string, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+
+	switch b {
+	case 0x1b:
+		// A lone Esc arrives on its own; sequences arrive in one read
+		if r.Buffered() == 0 {
+			return "esc", nil
+		}
+		if next, _ := r.ReadByte(); next != '[' && next != 'O' {
+			return "", nil // Alt+key
+		}
+		var seq []byte
+		for {
+			c, err := r.ReadByte()
+			if err != nil {
+				return "", err
+			}
+			seq = append(seq, c)
+			if c >= 0x40 && c <= 0x7e {
+				return reviewEscapeKeys[string(seq)], nil
+			}
+		}
+	case '\r', '\n':
+		return "enter", nil
+	case 0x7f, 0x08:
+		return "backspace", nil
+	case 0x03:
+		return "ctrl-c", nil
+	case 0x02:
+		return "pgup", nil // Ctrl-B
+	case 0x06:
+		return "pgdown", nil // Ctrl-F
+	}
+	if b < 0x20 {
+		return "", nil
+	}
+
+	r.UnreadByte()
+	c, _, err := r.ReadRune()
+	return string(c), err
+}
+
+// rows returns the rows of the list: every folder, followed by its files if
+// it is open.
+func (v *reviewScreen) rows() []reviewRow {
+	var rows []reviewRow
+	for g, group := range v.groups {
+		rows = append(rows, reviewRow{group: g, file: -1})
+		if v.open[group.folder] {
+			for k := range group.files {
+				rows = append(rows, reviewRow{group: g, file: k})
+			}
+		}
+	}
+	return rows
+}
+
+// selected returns the row under the cursor, and false if the list is
+// empty.
+func (v *reviewScreen) selected() (reviewRow, bool) {
+	rows := v.rows()
+	if len(rows) == 0 {
+		return reviewRow{}, false
+	}
+	v.cursor = max(0, min(v.cursor, len(rows)-1))
+	return rows[v.cursor], true
+}
+
+// selectedFiles returns the metas indexes of the selected file, or of every
+// file of the selected folder.
+func (v *reviewScreen) selectedFiles(row reviewRow) []int {
+	files := v.groups[row.group].files
+	if row.file < 0 {
+		return files
+	}
+	return files[row.file : row.file+1]
+}
+
+// follow moves the cursor to file i after the groups changed: to its row if
+// its folder is open and folderRow is false, to its folder otherwise.
+func (v *reviewScreen) follow(i int, folderRow bool) {
+	for r, row := range v.rows() {
+		files := v.groups[row.group].files
+		switch {
+		case row.file < 0 && containsInt(files, i):
+			v.cursor = r
+			if folderRow || !v.open[v.groups[row.group].folder] {
+				return
+			}
+		case row.file >= 0 && files[row.file] == i:
+			v.cursor = r
+			return
+		}
+	}
+}
+
+// containsInt reports whether values contains v.
+func containsInt(values []int, v int) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// ask shows question on the status line, with input pre-filled, and calls
+// answer with what the user types when they press Enter.
+func (v *reviewScreen) ask(question, input string, answer func(string) error) {
+	v.prompt, v.input, v.answer = question, []rune(input), answer
+}
+
+// regroup applies change to the selected folder, which may move it to
+// another day folder, then keeps the folder open if it was and the cursor
+// on the same row.
+func (v *reviewScreen) regroup(row reviewRow, change func(g int) error) error {
+	folder := v.groups[row.group].folder
+	anchor := v.selectedFiles(row)[0]
+	if err := change(row.group); err != nil {
+		return err
+	}
+	for _, group := range v.groups {
+		if containsInt(group.files, anchor) {
+			v.open[group.folder] = v.open[group.folder] || v.open[folder]
+			v.status = fmt.Sprintf("Now in %s", group.folder)
+		}
+	}
+	v.follow(anchor, row.file < 0)
+	return nil
+}
+
+// handleKey applies one key press. Returns true when the review is over,
+// and whether the plan was accepted.
+func (v *reviewScreen) handleKey(key string) (done, accepted bool) {
+	if v.prompt != "" {
+		v.editAnswer(key)
+		return false, false
+	}
+	if v.help {
+		v.help = false
+		return false, false
+	}
+
+	v.status = ""
+	switch key {
+	case "a":
+		return true, true
+	case "q", "ctrl-c":
+		return true, false
+	case "?":
+		v.help = true
+		return false, false
+	case "up", "k":
+		v.cursor--
+	case "down", "j":
+		v.cursor++
+	case "pgup":
+		v.cursor -= max(1, v.page)
+	case "pgdown":
+		v.cursor += max(1, v.page)
+	case "home", "g":
+		v.cursor = 0
+	case "end", "G":
+		v.cursor = len(v.rows()) - 1
+	}
+
+	row, ok := v.selected()
+	if !ok {
+		return false, false
+	}
+	folder := v.groups[row.group].folder
+	switch key {
+	case "enter":
+		if row.file < 0 {
+			v.open[folder] = !v.open[folder]
+		}
+	case "right", "l":
+		v.open[folder] = true
+	case "left", "h":
+		v.open[folder] = false
+		v.follow(v.groups[row.group].files[0], true)
+	case " ", "x":
+		files := v.selectedFiles(row)
+		v.exclude(files)
+		v.follow(files[0], row.file < 0)
+	case "d":
+		v.ask(fmt.Sprintf("Move %s to date (YYYY-MM-DD): ", folder), "", func(date string) error {
+			return v.regroup(row, func(g int) error { return v.moveGroup(g, strings.TrimSpace(date)) })
+		})
+	case "e":
+		label := v.metas[v.groups[row.group].files[0]].EventLabel
+		v.ask(fmt.Sprintf("Event label for %s (empty clears it): ", folder), label, func(label string) error {
+			return v.regroup(row, func(g int) error { return v.labelGroup(g, label) })
+		})
+	}
+	return false, false
+}
+
+// editAnswer applies a key pressed while a question is asked.
+func (v *reviewScreen) editAnswer(key string) {
+	switch {
+	case key == "enter":
+		answer := v.answer
+		v.prompt = ""
+		if err := answer(string(v.input)); err != nil {
+			v.status = "Error: " + err.Error()
+		}
+	case key == "esc" || key == "ctrl-c":
+		v.prompt = ""
+	case key == "backspace":
+		if len(v.input) > 0 {
+			v.input = v.input[:len(v.input)-1]
+		}
+	case utf8.RuneCountInString(key) == 1:
+		v.input = append(v.input, []rune(key)...)
+	}
+}
+
+// screenText is a run of text drawn in one style.
+type screenText struct {
+	text  string
+	style string
+}
+
+// screenLine draws parts cut to width columns; a selected line is drawn in
+// reverse video across the whole width.
+func screenLine(width int, selected bool, parts ...screenText) string {
+	var b strings.Builder
+	left := width
+	for _, p := range parts {
+		text := []rune(p.text)
+		if len(text) > left {
+			text = text[:left]
+		}
+		left -= len(text)
+		style := p.style
+		if selected {
+			style += styleSelected
+		}
+		b.WriteString(style + string(text) + styleReset)
+	}
+	if selected && left > 0 {
+		b.WriteString(styleSelected + strings.Repeat(" ", left) + styleReset)
+	}
+	return b.String()
+}
+
+// render draws the screen for a terminal width columns wide and height rows
+// high: a header, the list around the cursor, a status line and the keys.
+func (v *reviewScreen) render(width, height int) string {
+	width-- // Writing the last column wraps on some terminals
+	v.page = max(1, height-5)
+	var lines []string
+
+	shown, imported, unreadable := v.counts()
+	hidden := ""
+	if imported > 0 || unreadable > 0 {
+		hidden = fmt.Sprintf("  (%d already imported and %d unreadable files not shown)", imported, unreadable)
+	}
+	lines = append(lines,
+		screenLine(width, false,
+			screenText{fmt.Sprintf("Plan review: %d files in %d day folders, %d excluded", shown, len(v.groups), len(v.excluded)), styleBold},
+			screenText{hidden, styleDim}),
+		"")
+
+	if v.help {
+		for _, h := range reviewScreenHelp {
+			lines = append(lines, screenLine(width, false, screenText{"  " + h, ""}))
+		}
+	} else {
+		rows := v.rows()
+		v.selected()
+		if v.cursor < v.top {
+			v.top = v.cursor
+		}
+		if v.cursor >= v.top+v.page {
+			v.top = v.cursor - v.page + 1
+		}
+		v.top = max(0, min(v.top, len(rows)-v.page))
+
+		for r := v.top; r < len(rows) && r < v.top+v.page; r++ {
+			lines = append(lines, v.renderRow(width, rows[r], r == v.cursor))
+		}
+		if len(rows) == 0 {
+			lines = append(lines, screenLine(width, false, screenText{"  Nothing to organize", styleDim}))
+		}
+	}
+
+	for len(lines) < height-2 {
+		lines = append(lines, "")
+	}
+	switch {
+	case v.prompt != "":
+		lines = append(lines, screenLine(width, false, screenText{v.prompt, styleBold}, screenText{string(v.input) + "_", ""}))
+	case v.help:
+		lines = append(lines, screenLine(width, false, screenText{"Press any key to return to the list", styleDim}))
+	default:
+		lines = append(lines, screenLine(width, false, screenText{v.status, styleWarn}))
+	}
+	lines = append(lines, screenLine(width, false, screenText{reviewScreenKeys, styleDim}))
+
+	return strings.Join(lines, "\033[K\r\n") + "\033[K"
+}
+
+// renderRow draws one row of the list.
+func (v *reviewScreen) renderRow(width int, row reviewRow, selected bool) string {
+	group := v.groups[row.group]
+	if row.file < 0 {
+		marker := "▸"
+		if v.open[group.folder] {
+			marker = "▾"
+		}
+		style := ""
+		if v.allExcluded(group.files) {
+			style = styleDim
+		}
+		notes := v.groupNotes(row.group)
+		return screenLine(width, selected,
+			screenText{fmt.Sprintf("%s %-32s %5d files  ", marker, group.folder, len(group.files)), style},
+			screenText{notes, styleWarn})
+	}
+
+	i := group.files[row.file]
+	flags := v.flags(i)
+	style := ""
+	switch {
+	case flags == "x":
+		style = styleDim
+	case flags != "":
+		style = styleWarn
+	}
+	return screenLine(width, selected, screenText{fmt.Sprintf("    %-2s %s", flags, v.fileLine(i)), style})
+}
+
+// allExcluded reports whether every file in files is excluded.
+func (v *reviewScreen) allExcluded(files []int) bool {
+	for _, i := range files {
+		if !v.excluded[i] {
+			return false
+		}
+	}
+	return true
+}
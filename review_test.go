@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadKey(t *testing.T) {
+	keys := bufio.NewReader(strings.NewReader("\x1b[A\x1b[B\x1bOC\x1b[5~\x1b[Fj\r\x7f\x03é\x1b"))
+	want := []string{"up", "down", "right", "pgup", "end", "j", "enter", "backspace", "ctrl-c", "é", "esc"}
+	for _, w := range want {
+		got, err := readKey(keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != w {
+			t.Errorf("readKey = %q, want %q", got, w)
+		}
+	}
+}
+
+// testReviewScreen returns a review screen over two files on one day and
+// one on the next.
+func testReviewScreen(t *testing.T) *reviewScreen {
+	newTestLibrary(t)
+	day := time.Date(2024, 6, 1, 10, 0, 0, 0, time.Local)
+	meta := func(name string, at time.Time) fileMeta {
+		return fileMeta{Path: filepath.Join(incomingDir, "Phone", name), Size: 100, CaptureDate: at, DateSource: dateSourceExif}
+	}
+	s := &reviewState{
+		metas: []fileMeta{
+			meta("a.jpg", day),
+			meta("b.jpg", day.Add(time.Hour)),
+			meta("c.jpg", day.AddDate(0, 0, 1)),
+		},
+		excluded: make(map[int]bool),
+	}
+	s.rebuild()
+	return &reviewScreen{reviewState: s, open: make(map[string]bool)}
+}
+
+// press sends key presses to v, by name or as the character typed.
+func press(v *reviewScreen, keys ...string) (done, accepted bool) {
+	for _, k := range keys {
+		done, accepted = v.handleKey(k)
+	}
+	return done, accepted
+}
+
+// typeText types text into v, one character at a time.
+func typeText(v *reviewScreen, text string) {
+	for _, c := range text {
+		v.handleKey(string(c))
+	}
+}
+
+func TestReviewScreen(t *testing.T) {
+	v := testReviewScreen(t)
+	if len(v.rows()) != 2 {
+		t.Fatalf("got %d rows, want the 2 folders closed", len(v.rows()))
+	}
+
+	// Open the first folder and exclude its second file
+	press(v, "enter", "down", "down", " ")
+	if !v.excluded[1] || len(v.excluded) != 1 {
+		t.Errorf("excluded = %v, want file 1", v.excluded)
+	}
+	if row, _ := v.selected(); row.group != 0 || row.file != 1 {
+		t.Errorf("cursor on %+v, want the excluded file", row)
+	}
+
+	// Move the second folder to another day, then label it
+	press(v, "end", "d")
+	typeText(v, "2024-07-01")
+	press(v, "enter")
+	if m := v.metas[2]; m.CaptureDate.Format("2006-01-02 15:04") != "2024-07-01 10:00" || m.DateSource != dateSourceManual {
+		t.Errorf("moved file = %v (%s), want 2024-07-01 10:00 (manual)", m.CaptureDate, m.DateSource)
+	}
+	press(v, "e")
+	typeText(v, "Beach")
+	press(v, "enter")
+	if got := v.metas[2].EventLabel; got != "Beach" {
+		t.Errorf("label = %q, want Beach", got)
+	}
+	if row, _ := v.selected(); v.groups[row.group].folder != "2024/2024-07-01 Beach" {
+		t.Errorf("cursor on %q, want the relabelled folder", v.groups[row.group].folder)
+	}
+
+	// A bad date is reported and changes nothing
+	press(v, "d")
+	typeText(v, "july")
+	press(v, "enter")
+	if !strings.Contains(v.status, "invalid date") {
+		t.Errorf("status = %q, want an invalid date error", v.status)
+	}
+
+	// Esc cancels a question without applying it
+	press(v, "e", "backspace", "esc")
+	if v.metas[2].EventLabel != "Beach" || v.prompt != "" {
+		t.Errorf("label = %q, prompt = %q after Esc", v.metas[2].EventLabel, v.prompt)
+	}
+
+	if done, accepted := press(v, "a"); !done || !accepted {
+		t.Errorf("a: done = %v, accepted = %v", done, accepted)
+	}
+	if kept := v.accept(); len(kept) != 2 {
+		t.Errorf("accepted %d files, want 2", len(kept))
+	}
+	if done, accepted := testReviewScreen(t).handleKey("q"); !done || accepted {
+		t.Errorf("q: done = %v, accepted = %v", done, accepted)
+	}
+}
+
+func TestReviewScreenRender(t *testing.T) {
+	v := testReviewScreen(t)
+	v.exclude(v.groups[1].files)
+	screen := v.render(80, 10)
+
+	lines := strings.Split(screen, "\r\n")
+	if len(lines) != 10 {
+		t.Fatalf("got %d lines, want 10:\n%s", len(lines), screen)
+	}
+	plain := func(s string) string {
+		for _, seq := range []string{styleReset, styleBold, styleDim, styleWarn, styleSelected, "\033[K"} {
+			s = strings.ReplaceAll(s, seq, "")
+		}
+		return s
+	}
+	for _, l := range lines {
+		if n := len([]rune(plain(l))); n > 79 {
+			t.Errorf("line is %d columns wide, want at most 79: %q", n, plain(l))
+		}
+	}
+	if !strings.Contains(lines[2], styleSelected) || !strings.Contains(plain(lines[2]), "2024/2024-06-01") {
+		t.Errorf("first folder is not selected: %q", lines[2])
+	}
+	if !strings.Contains(plain(lines[3]), "x 1 excluded") {
+		t.Errorf("excluded folder not marked: %q", plain(lines[3]))
+	}
+}
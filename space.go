@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// =============================================================================
+// Disk Space Preflight
+// =============================================================================
+
+// spaceReserve is the number of bytes that must remain free on the
+// destination filesystem after a run, set by the --reserve flag.
+var spaceReserve uint64 = 1 << 30
+
+// errInsufficientSpace is returned when a run would not fit on the destination.
+var errInsufficientSpace = errors.New("not enough free space")
+
+// spaceNeed is how much a run will write to one destination filesystem.
+type spaceNeed struct {
+	device    string // Filesystem identifier
+	dir       string // An existing directory on that filesystem
+	bytes     uint64 // Bytes the run will write there
+	files     int    // Number of files contributing to bytes
+	available uint64 // Free bytes available to this user
+}
+
+// checkFreeSpace sums the bytes each planned transfer will write to its
+// destination filesystem and compares them with the free space there.
+// Moves within one filesystem are renames and hardlinks share data, so
+// neither needs space; copies, reflinks (which may fall back to a copy) and
+// moves across filesystems do. Prints a report and, unless dryRun is set,
+// returns errInsufficientSpace if any filesystem would end up with less than
+// spaceReserve bytes free.
+func checkFreeSpace(plan []planEntry, mode transferMode, dryRun bool) error {
+	if mode == modeHardlink {
+		return nil
+	}
+
+	needs := make(map[string]*spaceNeed)
+	dirDevices := make(map[string]*spaceNeed) // Destination dir -> its filesystem
+
+	for _, entry := range plan {
+		if entry.Action != actionTransfer {
+			continue
+		}
+
+		destDir := filepath.Dir(entry.DestPath)
+		need, ok := dirDevices[destDir]
+		if !ok {
+			existing := nearestExistingDir(destDir)
+			dev, err := deviceID(existing)
+			if err != nil {
+				// Can't identify the filesystem on this platform; skip the check
+				return nil
+			}
+			if need = needs[dev]; need == nil {
+				avail, err := freeSpace(existing)
+				if err != nil {
+					return nil
+				}
+				need = &spaceNeed{device: dev, dir: existing, available: avail}
+				needs[dev] = need
+			}
+			dirDevices[destDir] = need
+		}
+
+		if mode == modeMove {
+			// Renames within a filesystem don't consume space
+			if srcDev, err := deviceID(entry.SrcPath); err == nil && srcDev == need.device {
+				continue
+			}
+		}
+		need.bytes += uint64(entry.Size)
+		need.files++
+	}
+
+	var devices []string
+	for dev := range needs {
+		devices = append(devices, dev)
+	}
+	sort.Strings(devices)
+
+	short := false
+	for _, dev := range devices {
+		need := needs[dev]
+		if need.bytes == 0 {
+			continue
+		}
+		if need.bytes+spaceReserve <= need.available {
+			logf(levelInfo, "Space check: %s to write (%d files), %s free on %s\n\n",
+				formatSize(need.bytes), need.files, formatSize(need.available), need.dir)
+			continue
+		}
+
+		short = true
+		logf(levelError, "Not enough free space on %s:\n", need.dir)
+		logf(levelError, "  Needed:    %s (%d files)\n", formatSize(need.bytes), need.files)
+		logf(levelError, "  Reserve:   %s\n", formatSize(spaceReserve))
+		logf(levelError, "  Available: %s\n", formatSize(need.available))
+		logf(levelError, "  Short by:  %s\n\n", formatSize(need.bytes+spaceReserve-need.available))
+	}
+
+	if short && !dryRun {
+		return fmt.Errorf("%w on destination; free up space or lower --reserve", errInsufficientSpace)
+	}
+	return nil
+}
+
+// nearestExistingDir returns dir or its closest ancestor that exists.
+// Destination folders are usually created during the run.
+func nearestExistingDir(dir string) string {
+	for {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// sizeUnits maps size suffixes to their multipliers. Decimal and binary
+// suffixes are both accepted and both mean powers of 1024.
+var sizeUnits = []struct {
+	suffix string
+	mult   float64
+}{
+	{"TIB", 1 << 40}, {"TB", 1 << 40}, {"T", 1 << 40},
+	{"GIB", 1 << 30}, {"GB", 1 << 30}, {"G", 1 << 30},
+	{"MIB", 1 << 20}, {"MB", 1 << 20}, {"M", 1 << 20},
+	{"KIB", 1 << 10}, {"KB", 1 << 10}, {"K", 1 << 10},
+	{"B", 1},
+}
+
+// parseSize parses a human-readable size such as "500MB", "1.5G" or "4096".
+func parseSize(s string) (uint64, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	mult := 1.0
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(str, u.suffix) {
+			str = strings.TrimSpace(strings.TrimSuffix(str, u.suffix))
+			mult = u.mult
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(str, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return uint64(n * mult), nil
+}
+
+// formatSize formats a byte count for display, e.g. "1.5 GB".
+func formatSize(bytes uint64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	size := float64(bytes)
+	i := 0
+	for size >= 1024 && i < len(units)-1 {
+		size /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	return fmt.Sprintf("%.1f %s", size, units[i])
+}
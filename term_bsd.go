@@ -0,0 +1,11 @@
+//go:build darwin || freebsd
+
+package main
+
+import "syscall"
+
+// ioctl requests to get and set terminal attributes.
+const (
+	ioctlGetTermios = syscall.TIOCGETA
+	ioctlSetTermios = syscall.TIOCSETA
+)
@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// makeRawTerminal is not supported on this platform; the review falls back
+// to reading commands line by line.
+func makeRawTerminal(in, out *os.File) (func(), error) {
+	return nil, errors.New("raw terminal mode is not supported on this platform")
+}
+
+// terminalSize is not supported on this platform.
+func terminalSize(f *os.File) (int, int) {
+	return 80, 24
+}
@@ -0,0 +1,74 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procSetConsoleMode             = syscall.NewLazyDLL("kernel32.dll").NewProc("SetConsoleMode")
+	procGetConsoleScreenBufferInfo = syscall.NewLazyDLL("kernel32.dll").NewProc("GetConsoleScreenBufferInfo")
+)
+
+const (
+	enableProcessedInput            = 0x1
+	enableLineInput                 = 0x2
+	enableEchoInput                 = 0x4
+	enableVirtualTerminalInput      = 0x200
+	enableVirtualTerminalProcessing = 0x4
+)
+
+// makeRawTerminal puts the console on in into raw mode, so keys arrive as
+// they are pressed, without echo or line editing, and as the same escape
+// sequences as on other platforms; out gets escape sequence processing.
+// Returns a function that restores the previous modes.
+func makeRawTerminal(in, out *os.File) (func(), error) {
+	inHandle, outHandle := syscall.Handle(in.Fd()), syscall.Handle(out.Fd())
+	var inMode, outMode uint32
+	if err := syscall.GetConsoleMode(inHandle, &inMode); err != nil {
+		return nil, err
+	}
+	if err := syscall.GetConsoleMode(outHandle, &outMode); err != nil {
+		return nil, err
+	}
+
+	raw := inMode&^(enableProcessedInput|enableLineInput|enableEchoInput) | enableVirtualTerminalInput
+	if err := setConsoleMode(inHandle, raw); err != nil {
+		return nil, err
+	}
+	if err := setConsoleMode(outHandle, outMode|enableVirtualTerminalProcessing); err != nil {
+		setConsoleMode(inHandle, inMode)
+		return nil, err
+	}
+	return func() {
+		setConsoleMode(inHandle, inMode)
+		setConsoleMode(outHandle, outMode)
+	}, nil
+}
+
+// setConsoleMode sets the mode of a console handle.
+func setConsoleMode(h syscall.Handle, mode uint32) error {
+	if r, _, err := procSetConsoleMode.Call(uintptr(h), uintptr(mode)); r == 0 {
+		return err
+	}
+	return nil
+}
+
+// terminalSize returns the width and height of the console window on f, or
+// 80x24 if it can't be read.
+func terminalSize(f *os.File) (int, int) {
+	var info struct {
+		size, cursor             struct{ x, y int16 }
+		attributes               uint16
+		left, top, right, bottom int16
+		maxWindow                struct{ x, y int16 }
+	}
+	r, _, _ := procGetConsoleScreenBufferInfo.Call(f.Fd(), uintptr(unsafe.Pointer(&info)))
+	if r == 0 {
+		return 80, 24
+	}
+	return int(info.right-info.left) + 1, int(info.bottom-info.top) + 1
+}
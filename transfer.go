@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// =============================================================================
+// Transfer Modes
+// =============================================================================
+
+// transferMode selects how a file gets from Incoming into Originals.
+type transferMode string
+
+const (
+	modeMove     transferMode = "move"     // Rename, or verified copy+delete across devices
+	modeCopy     transferMode = "copy"     // Verified copy, source left untouched
+	modeHardlink transferMode = "hardlink" // Hard link, source and destination share the inode
+	modeReflink  transferMode = "reflink"  // Copy-on-write clone, falls back to copy
+)
+
+// transferModes lists the valid --mode values in display order.
+var transferModes = []transferMode{modeMove, modeCopy, modeHardlink, modeReflink}
+
+// parseTransferMode validates a --mode flag value.
+func parseTransferMode(s string) (transferMode, error) {
+	for _, m := range transferModes {
+		if transferMode(strings.ToLower(s)) == m {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("unknown mode %q (valid: move, copy, hardlink, reflink)", s)
+}
+
+// keepsSource returns true if the mode leaves the source file in place.
+// Files transferred in these modes are recorded in the import ledger so they
+// are not imported again on the next run.
+func (m transferMode) keepsSource() bool {
+	return m != modeMove
+}
+
+// transferFile puts src at dst using the given mode.
+// dst must not exist yet.
+func transferFile(mode transferMode, src, dst string) error {
+	switch mode {
+	case modeMove:
+		return moveFile(src, dst)
+	case modeCopy:
+		return copyFile(src, dst)
+	case modeHardlink:
+		return os.Link(src, dst)
+	case modeReflink:
+		if err := reflinkFile(src, dst); err == nil {
+			return nil
+		}
+		return copyFile(src, dst)
+	}
+	return fmt.Errorf("unknown mode %q", mode)
+}
+
+// reflinkFile clones src to dst with a copy-on-write reflink.
+// The clone is made into a temporary file and renamed into place so dst is
+// never left partially written. Permissions and modification time are kept.
+// Returns an error if the filesystem or platform does not support reflinks.
+func reflinkFile(src, dst string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	srcInfo, err := srcFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	err = cloneFile(tmp, srcFile)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, srcInfo.Mode().Perm())
+	}
+	if err == nil {
+		err = os.Chtimes(tmpPath, srcInfo.ModTime(), srcInfo.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, dst)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	syncDir(filepath.Dir(dst))
+	return nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// =============================================================================
+// Source Verification
+// =============================================================================
+
+// libraryIndex finds library files with the same content as a source file.
+// Candidates come from the manifest first (by size and partial hash); if the
+// manifest has none, Originals/ is scanned once and indexed by size. Every
+// candidate is confirmed with a full SHA-256 comparison.
+type libraryIndex struct {
+	manifest   map[string][]string // "size:hash" -> manifest relative paths
+	bySize     map[int64][]string  // Originals file paths by size (built lazily)
+	fullHashes map[string]string   // Library path -> SHA-256 (memoized)
+	scanned    bool
+}
+
+// newLibraryIndex loads the manifest into a new index.
+func newLibraryIndex() (*libraryIndex, error) {
+	idx := &libraryIndex{
+		manifest:   make(map[string][]string),
+		fullHashes: make(map[string]string),
+	}
+
+	m, err := openManifest()
+	if err != nil {
+		return nil, err
+	}
+	defer m.close()
+
+	recs, err := m.records()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range recs {
+		key := strconv.FormatInt(r.Size, 10) + ":" + r.Hash
+		idx.manifest[key] = append(idx.manifest[key], r.RelativePath)
+	}
+
+	return idx, nil
+}
+
+// find returns the library path holding the same content as a source file
+// with the given size, partial hash and full SHA-256, and whether the match
+// came from the manifest.
+func (idx *libraryIndex) find(size int64, partial, full string) (string, bool, bool) {
+	key := strconv.FormatInt(size, 10) + ":" + partial
+	for _, rel := range idx.manifest[key] {
+		path := filepath.Join(photoRoot, filepath.FromSlash(rel))
+		if idx.fullHash(path) == full {
+			return path, true, true
+		}
+	}
+
+	if !idx.scanned {
+		idx.scanOriginals()
+	}
+	for _, path := range idx.bySize[size] {
+		if idx.fullHash(path) == full {
+			return path, false, true
+		}
+	}
+
+	return "", false, false
+}
+
+// scanOriginals indexes every file in Originals/ by size.
+func (idx *libraryIndex) scanOriginals() {
+	idx.bySize = make(map[int64][]string)
+	filepath.Walk(originalsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		idx.bySize[info.Size()] = append(idx.bySize[info.Size()], path)
+		return nil
+	})
+	idx.scanned = true
+}
+
+// fullHash returns the SHA-256 of a library file, or "" if it can't be read.
+// Unchanged library files are served from the metadata cache.
+func (idx *libraryIndex) fullHash(path string) string {
+	if h, ok := idx.fullHashes[path]; ok {
+		return h
+	}
+
+	h := ""
+	if info, err := os.Stat(path); err == nil {
+		if e, ok := metaCache.lookup(path, info); ok && e.SHA256 != "" {
+			h = e.SHA256
+		} else if h, err = hashFile(path); err == nil {
+			metaCache.update(path, info, func(e *cacheEntry) { e.SHA256 = h })
+		}
+	}
+
+	idx.fullHashes[path] = h
+	return h
+}
+
+// runVerifySource implements the verify-source subcommand: check that every
+// media file on a card or folder is safely stored in the library before the
+// card is formatted. Files are found with the same rules as the organizer.
+// Returns exitOK if every file is in the library, exitPartial if some are
+// missing, and exitFatal if nothing could be verified.
+func runVerifySource(args []string) int {
+	fs := flag.NewFlagSet("verify-source", flag.ExitOnError)
+	rootDir := fs.String("root", "", "Photo library root directory (default: current directory)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Verify that every media file on a card is safely stored in the library\n\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "  %s verify-source [options] <path>\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	if err := setLibraryPaths(*rootDir); err != nil {
+		fmt.Println("Error getting current directory:", err)
+		return exitFatal
+	}
+
+	src, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		fmt.Println("Error:", err)
+		return exitFatal
+	}
+	if info, err := os.Stat(src); err != nil || !info.IsDir() {
+		fmt.Printf("Error: source not found at %s\n", src)
+		return exitFatal
+	}
+
+	metaCache = loadMetadataCache(cacheFile)
+	defer saveMetadataCache()
+
+	idx, err := newLibraryIndex()
+	if err != nil {
+		fmt.Println("Error reading manifest:", err)
+		return exitFatal
+	}
+
+	files, err := findMediaFiles(src)
+	if err != nil {
+		fmt.Println("Error scanning source:", err)
+		return exitFatal
+	}
+
+	if len(files) == 0 {
+		// An empty card usually means the wrong path or an unmounted card;
+		// never report it as safe to format
+		fmt.Printf("FAIL: no media files found in %s - nothing was verified\n", src)
+		return exitFatal
+	}
+
+	fmt.Printf("Verifying %d files in %s against %s\n\n", len(files), src, originalsDir)
+
+	viaManifest, viaScan, missing := 0, 0, 0
+	for _, path := range files {
+		rel, _ := filepath.Rel(src, path)
+
+		info, err := os.Stat(path)
+		var full string
+		if err == nil {
+			full, err = hashFile(path)
+		}
+		if err != nil {
+			fmt.Printf("  ✗ %s (cannot read: %v)\n", rel, err)
+			missing++
+			continue
+		}
+
+		_, inManifest, found := idx.find(info.Size(), getFileHash(path), full)
+		switch {
+		case !found:
+			fmt.Printf("  ✗ %s (not in library)\n", rel)
+			missing++
+		case inManifest:
+			viaManifest++
+		default:
+			viaScan++
+		}
+	}
+
+	if missing > 0 {
+		fmt.Println()
+	}
+	fmt.Printf("Verified: %d files (%d via manifest, %d via library scan)\n", viaManifest+viaScan, viaManifest, viaScan)
+	fmt.Printf("Missing:  %d files\n\n", missing)
+
+	if missing > 0 {
+		fmt.Printf("FAIL: %d of %d files are not safely stored - do not format this card\n", missing, len(files))
+		return exitPartial
+	}
+	fmt.Printf("PASS: all %d files are safely stored in the library - safe to format\n", len(files))
+	return exitOK
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// =============================================================================
+// Video Metadata
+// =============================================================================
+
+// videoInfo is the stream metadata read from a video's container. The frame
+// size goes into cameraInfo's Width and Height, as a photo's does. Zero
+// values mean the field is unknown.
+type videoInfo struct {
+	Duration  float64 `json:"duration_s,omitempty"` // Seconds
+	FrameRate float64 `json:"frame_rate,omitempty"` // Frames per second
+	Codec     string  `json:"codec,omitempty"`      // FourCC, e.g. "avc1" or "hvc1"
+}
+
+// videoColumns are the manifest and cache columns of a videoInfo, in the
+// order of values and parseVideoInfo.
+var videoColumns = []string{"duration_s", "frame_rate", "video_codec"}
+
+// values returns v's column values, with "" for unknown fields.
+func (v videoInfo) values() []string {
+	return []string{formatOptionalFloat(v.Duration), formatOptionalFloat(v.FrameRate), v.Codec}
+}
+
+// parseVideoInfo parses column values written by values. Missing or
+// invalid values are left zero.
+func parseVideoInfo(vals []string) videoInfo {
+	var v videoInfo
+	if len(vals) != len(videoColumns) {
+		return v
+	}
+	v.Duration, _ = strconv.ParseFloat(vals[0], 64)
+	v.FrameRate, _ = strconv.ParseFloat(vals[1], 64)
+	v.Codec = vals[2]
+	return v
+}
+
+// readVideoInfo reads the metadata of the first video track from the
+// container in r, a file with extension ext: MP4/MOV boxes or Matroska
+// elements. Only the headers are read, never the media data. Returns zero
+// values for containers it can't parse.
+func readVideoInfo(r io.ReadSeeker, ext string) (v videoInfo, width, height int) {
+	switch strings.ToLower(ext) {
+	case ".mp4", ".mov":
+		return readMP4Info(r)
+	case ".mkv":
+		return readMatroskaInfo(r)
+	}
+	return v, 0, 0
+}
+
+// =============================================================================
+// MP4 / QuickTime
+// =============================================================================
+
+// mp4MaxMoovSize caps how much of a moov box is read into memory. Its
+// sample tables grow with the length of the video, but stay far below this.
+const mp4MaxMoovSize = 64 << 20
+
+// mp4MaxDepth caps how deep parseMP4Track descends into nested boxes. A
+// trak holds its sample table three levels down (mdia/minf/stbl); the cap
+// stops a crafted file from nesting those boxes until the stack overflows.
+const mp4MaxDepth = 8
+
+// mp4Track is what readMP4Info needs from one trak box.
+type mp4Track struct {
+	video     bool
+	codec     string
+	width     int
+	height    int
+	frameRate float64
+}
+
+// readMP4Info reads an MP4 or QuickTime file's moov box: the duration from
+// mvhd, and the frame size, codec and frame rate of the first video track
+// from its tkhd, stsd and stts.
+func readMP4Info(r io.ReadSeeker) (v videoInfo, width, height int) {
+	moov, err := findMP4Box(r, "moov")
+	if err != nil {
+		return v, 0, 0
+	}
+
+	mp4Boxes(moov, func(typ string, b []byte) {
+		switch typ {
+		case "mvhd":
+			if timescale, duration := mp4TimescaleDuration(b); timescale > 0 {
+				v.Duration = roundTo(float64(duration)/float64(timescale), 3)
+			}
+		case "trak":
+			if t := parseMP4Track(b); t.video && v.Codec == "" {
+				v.Codec, v.FrameRate = t.codec, t.frameRate
+				width, height = t.width, t.height
+			}
+		}
+	})
+	return v, width, height
+}
+
+// findMP4Box returns the payload of the first top-level box of type want,
+// seeking past the others (including the media data) without reading them.
+func findMP4Box(r io.ReadSeeker, want string) ([]byte, error) {
+	offset := int64(0)
+	for {
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		var hdr [16]byte
+		if _, err := io.ReadFull(r, hdr[:8]); err != nil {
+			return nil, err
+		}
+		size := int64(binary.BigEndian.Uint32(hdr[:4]))
+		typ := string(hdr[4:8])
+		hdrLen := int64(8)
+		switch size {
+		case 0: // Box runs to the end of the file
+			end, err := r.Seek(0, io.SeekEnd)
+			if err != nil {
+				return nil, err
+			}
+			size = end - offset
+			r.Seek(offset+hdrLen, io.SeekStart)
+		case 1: // 64-bit size follows the type
+			if _, err := io.ReadFull(r, hdr[8:16]); err != nil {
+				return nil, err
+			}
+			size = int64(binary.BigEndian.Uint64(hdr[8:16]))
+			hdrLen = 16
+		}
+		if size < hdrLen {
+			return nil, errors.New("invalid MP4 box size")
+		}
+
+		if typ == want {
+			if size-hdrLen > mp4MaxMoovSize {
+				return nil, errors.New("MP4 box too large")
+			}
+			payload := make([]byte, size-hdrLen)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return nil, err
+			}
+			return payload, nil
+		}
+		offset += size
+	}
+}
+
+// mp4Boxes calls fn with the type and payload of each box in data.
+// A truncated box ends the walk.
+func mp4Boxes(data []byte, fn func(typ string, payload []byte)) {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		typ := string(data[4:8])
+		hdrLen := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			hdrLen = 16
+		}
+		if size < hdrLen || size > uint64(len(data)) {
+			return
+		}
+		fn(typ, data[hdrLen:size])
+		data = data[size:]
+	}
+}
+
+// parseMP4Track reads a trak box.
+func parseMP4Track(trak []byte) mp4Track {
+	var t mp4Track
+	var timescale, duration, samples uint64
+	var entryWidth, entryHeight int
+
+	depth := 0
+	var walk func(typ string, b []byte)
+	walk = func(typ string, b []byte) {
+		switch typ {
+		case "mdia", "minf", "stbl":
+			if depth < mp4MaxDepth {
+				depth++
+				mp4Boxes(b, walk)
+				depth--
+			}
+		case "tkhd":
+			// Width and height are 16.16 fixed point after the matrix
+			off := 76
+			if len(b) > 0 && b[0] == 1 {
+				off = 88
+			}
+			if len(b) >= off+8 {
+				t.width = int(binary.BigEndian.Uint32(b[off:]) >> 16)
+				t.height = int(binary.BigEndian.Uint32(b[off+4:]) >> 16)
+			}
+		case "mdhd":
+			timescale, duration = mp4TimescaleDuration(b)
+		case "hdlr":
+			t.video = len(b) >= 12 && string(b[8:12]) == "vide"
+		case "stsd":
+			// The first sample entry's type is the codec; a visual entry
+			// also holds the coded frame size
+			if len(b) >= 16 {
+				t.codec = strings.TrimRight(string(b[12:16]), " \x00")
+			}
+			if len(b) >= 44 {
+				entryWidth = int(binary.BigEndian.Uint16(b[40:]))
+				entryHeight = int(binary.BigEndian.Uint16(b[42:]))
+			}
+		case "stts":
+			if len(b) < 8 {
+				return
+			}
+			n := int(binary.BigEndian.Uint32(b[4:]))
+			for i := 0; i < n && 8+i*8+8 <= len(b); i++ {
+				samples += uint64(binary.BigEndian.Uint32(b[8+i*8:]))
+			}
+		}
+	}
+	mp4Boxes(trak, walk)
+
+	if t.width == 0 || t.height == 0 {
+		t.width, t.height = entryWidth, entryHeight
+	}
+	if timescale > 0 && duration > 0 && samples > 0 {
+		t.frameRate = roundTo(float64(samples)*float64(timescale)/float64(duration), 3)
+	}
+	return t
+}
+
+// mp4TimescaleDuration reads the timescale and duration of an mvhd or mdhd
+// box, which share their layout up to the duration.
+func mp4TimescaleDuration(b []byte) (timescale, duration uint64) {
+	if len(b) >= 32 && b[0] == 1 {
+		return uint64(binary.BigEndian.Uint32(b[20:])), binary.BigEndian.Uint64(b[24:])
+	}
+	if len(b) >= 20 {
+		return uint64(binary.BigEndian.Uint32(b[12:])), uint64(binary.BigEndian.Uint32(b[16:]))
+	}
+	return 0, 0
+}
+
+// =============================================================================
+// Matroska
+// =============================================================================
+
+// Matroska element IDs read by readMatroskaInfo.
+const (
+	ebmlIDHeader         = 0x1A45DFA3
+	mkvIDSegment         = 0x18538067
+	mkvIDInfo            = 0x1549A966
+	mkvIDTimecodeScale   = 0x2AD7B1
+	mkvIDDuration        = 0x4489
+	mkvIDTracks          = 0x1654AE6B
+	mkvIDTrackEntry      = 0xAE
+	mkvIDTrackType       = 0x83
+	mkvIDCodecID         = 0x86
+	mkvIDDefaultDuration = 0x23E383
+	mkvIDVideo           = 0xE0
+	mkvIDPixelWidth      = 0xB0
+	mkvIDPixelHeight     = 0xBA
+	mkvIDCluster         = 0x1F43B675
+)
+
+const (
+	mkvTrackTypeVideo       = 1
+	mkvDefaultTimecodeScale = 1000000 // Nanoseconds per tick
+
+	// mkvMaxHeaderSize caps how much of an Info or Tracks element is read
+	// into memory.
+	mkvMaxHeaderSize = 16 << 20
+)
+
+// matroskaCodecs maps Matroska codec IDs to the FourCC the same codec has
+// in MP4, so queries don't depend on the container. Other IDs are kept.
+var matroskaCodecs = map[string]string{
+	"V_MPEG4/ISO/AVC":  "avc1",
+	"V_MPEGH/ISO/HEVC": "hvc1",
+	"V_AV1":            "av01",
+	"V_VP8":            "vp08",
+	"V_VP9":            "vp09",
+	"V_MPEG4/ISO/SP":   "mp4v",
+	"V_MPEG4/ISO/ASP":  "mp4v",
+	"V_MJPEG":          "mjpg",
+}
+
+// readMatroskaInfo reads a Matroska file's Segment Info (duration) and
+// Tracks (frame size, codec and frame rate of the first video track),
+// seeking past everything else. It stops at the first Cluster: muxers write
+// both before the media data.
+func readMatroskaInfo(r io.ReadSeeker) (v videoInfo, width, height int) {
+	if id, size, err := readEBMLElementHeader(r); err != nil || id != ebmlIDHeader || size < 0 {
+		return v, 0, 0
+	} else if _, err := r.Seek(size, io.SeekCurrent); err != nil {
+		return v, 0, 0
+	}
+	// The Segment may have an unknown size; its children are read in turn
+	if id, _, err := readEBMLElementHeader(r); err != nil || id != mkvIDSegment {
+		return v, 0, 0
+	}
+
+	scale := uint64(mkvDefaultTimecodeScale)
+	var duration float64
+	haveInfo, haveTracks := false, false
+	for !haveInfo || !haveTracks {
+		id, size, err := readEBMLElementHeader(r)
+		if err != nil || size < 0 || id == mkvIDCluster {
+			break
+		}
+		if id != mkvIDInfo && id != mkvIDTracks {
+			if _, err := r.Seek(size, io.SeekCurrent); err != nil {
+				break
+			}
+			continue
+		}
+		if size > mkvMaxHeaderSize {
+			break
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			break
+		}
+
+		if id == mkvIDInfo {
+			haveInfo = true
+			ebmlElements(data, func(id uint64, b []byte) {
+				switch id {
+				case mkvIDTimecodeScale:
+					if s := ebmlUint(b); s > 0 {
+						scale = s
+					}
+				case mkvIDDuration:
+					duration = ebmlFloat(b)
+				}
+			})
+			continue
+		}
+
+		haveTracks = true
+		ebmlElements(data, func(id uint64, b []byte) {
+			if id != mkvIDTrackEntry || v.Codec != "" {
+				return
+			}
+			var trackType, frameNs uint64
+			var codec string
+			var w, h int
+			ebmlElements(b, func(id uint64, b []byte) {
+				switch id {
+				case mkvIDTrackType:
+					trackType = ebmlUint(b)
+				case mkvIDCodecID:
+					codec = strings.TrimRight(string(b), "\x00")
+				case mkvIDDefaultDuration:
+					frameNs = ebmlUint(b)
+				case mkvIDVideo:
+					ebmlElements(b, func(id uint64, b []byte) {
+						switch id {
+						case mkvIDPixelWidth:
+							w = int(ebmlUint(b))
+						case mkvIDPixelHeight:
+							h = int(ebmlUint(b))
+						}
+					})
+				}
+			})
+			if trackType != mkvTrackTypeVideo {
+				return
+			}
+			if fourCC, ok := matroskaCodecs[codec]; ok {
+				codec = fourCC
+			}
+			v.Codec, width, height = codec, w, h
+			if frameNs > 0 {
+				v.FrameRate = roundTo(1e9/float64(frameNs), 3)
+			}
+		})
+	}
+
+	if duration > 0 {
+		v.Duration = roundTo(duration*float64(scale)/1e9, 3)
+	}
+	return v, width, height
+}
+
+// readEBMLElementHeader reads an element's ID and data size from r. The size
+// is -1 if it is unknown (the element runs to the end of its parent).
+func readEBMLElementHeader(r io.Reader) (id uint64, size int64, err error) {
+	readVint := func(keepMarker bool) (uint64, bool, error) {
+		var buf [8]byte
+		if _, err := io.ReadFull(r, buf[:1]); err != nil {
+			return 0, false, err
+		}
+		n := bits.LeadingZeros8(buf[0]) + 1
+		if n > 8 {
+			return 0, false, errors.New("invalid EBML variable-length integer")
+		}
+		if _, err := io.ReadFull(r, buf[1:n]); err != nil {
+			return 0, false, err
+		}
+		v, unknown := decodeEBMLVint(buf[:n], keepMarker)
+		return v, unknown, nil
+	}
+
+	if id, _, err = readVint(true); err != nil {
+		return 0, 0, err
+	}
+	n, unknown, err := readVint(false)
+	if err != nil {
+		return 0, 0, err
+	}
+	if unknown || n > math.MaxInt64 {
+		return id, -1, nil
+	}
+	return id, int64(n), nil
+}
+
+// decodeEBMLVint decodes an EBML variable-length integer. Element IDs keep
+// their length marker bit; sizes drop it, and are unknown if every
+// remaining bit is set.
+func decodeEBMLVint(b []byte, keepMarker bool) (v uint64, unknown bool) {
+	n := len(b)
+	v = uint64(b[0])
+	if !keepMarker {
+		v &^= 0x80 >> (n - 1)
+	}
+	for _, c := range b[1:] {
+		v = v<<8 | uint64(c)
+	}
+	return v, !keepMarker && v == 1<<(7*n)-1
+}
+
+// ebmlElements calls fn with the ID and data of each element in data.
+// An element running past the end of data (or of unknown size) gets the
+// rest of it.
+func ebmlElements(data []byte, fn func(id uint64, data []byte)) {
+	next := func(keepMarker bool) (uint64, bool) {
+		if len(data) == 0 {
+			return 0, false
+		}
+		n := bits.LeadingZeros8(data[0]) + 1
+		if n > 8 || n > len(data) {
+			return 0, false
+		}
+		v, _ := decodeEBMLVint(data[:n], keepMarker)
+		data = data[n:]
+		return v, true
+	}
+
+	for len(data) > 0 {
+		id, ok := next(true)
+		if !ok {
+			return
+		}
+		size, ok := next(false)
+		if !ok {
+			return
+		}
+		if size > uint64(len(data)) {
+			size = uint64(len(data))
+		}
+		fn(id, data[:size])
+		data = data[size:]
+	}
+}
+
+// ebmlUint decodes an EBML unsigned integer element.
+func ebmlUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// ebmlFloat decodes an EBML float element, 4 or 8 bytes.
+func ebmlFloat(b []byte) float64 {
+	switch len(b) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
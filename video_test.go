@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// =============================================================================
+// MP4 Fixtures
+// =============================================================================
+
+// mp4Box builds a box of type typ holding the concatenated parts.
+func mp4Box(typ string, parts ...[]byte) []byte {
+	payload := bytes.Join(parts, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(payload)))
+	return append(append(b, typ...), payload...)
+}
+
+// mp4LargeBox builds a box with a 64-bit size.
+func mp4LargeBox(typ string, payload []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, 1)
+	b = append(b, typ...)
+	b = binary.BigEndian.AppendUint64(b, uint64(16+len(payload)))
+	return append(b, payload...)
+}
+
+// mp4Header builds a version 0 mvhd or mdhd box.
+func mp4Header(typ string, timescale, duration uint32) []byte {
+	b := make([]byte, 20)
+	binary.BigEndian.PutUint32(b[12:], timescale)
+	binary.BigEndian.PutUint32(b[16:], duration)
+	return mp4Box(typ, b)
+}
+
+// mp4Trak builds a trak box for a track with the given handler, codec,
+// display size (tkhd), coded size (stsd), media timescale and duration, and
+// sample count.
+func mp4Trak(handler, codec string, width, height, codedWidth, codedHeight int, timescale, duration, samples uint32) []byte {
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[76:], uint32(width)<<16)
+	binary.BigEndian.PutUint32(tkhd[80:], uint32(height)<<16)
+
+	hdlr := make([]byte, 24)
+	copy(hdlr[8:], handler)
+
+	stsd := make([]byte, 44)
+	binary.BigEndian.PutUint32(stsd[4:], 1)
+	copy(stsd[12:], codec)
+	binary.BigEndian.PutUint16(stsd[40:], uint16(codedWidth))
+	binary.BigEndian.PutUint16(stsd[42:], uint16(codedHeight))
+
+	stts := make([]byte, 16)
+	binary.BigEndian.PutUint32(stts[4:], 1)
+	binary.BigEndian.PutUint32(stts[8:], samples)
+	binary.BigEndian.PutUint32(stts[12:], duration/samples)
+
+	return mp4Box("trak",
+		mp4Box("tkhd", tkhd),
+		mp4Box("mdia",
+			mp4Header("mdhd", timescale, duration),
+			mp4Box("hdlr", hdlr),
+			mp4Box("minf", mp4Box("stbl", mp4Box("stsd", stsd), mp4Box("stts", stts)))))
+}
+
+func TestReadMP4Info(t *testing.T) {
+	ftyp := mp4Box("ftyp", []byte("isom\x00\x00\x02\x00isomavc1"))
+	video := mp4Trak("vide", "avc1", 1920, 1080, 1920, 1088, 30000, 300300, 300)
+	audio := mp4Trak("soun", "mp4a", 0, 0, 0, 0, 48000, 480000, 469)
+
+	// A video track nested far deeper than mdia/minf/stbl
+	entry := make([]byte, 44)
+	copy(entry[12:], "avc1")
+	deep := mp4Box("stsd", entry)
+	for i := 0; i < 1000; i++ {
+		deep = mp4Box("stbl", deep)
+	}
+	deepTrak := mp4Box("trak", mp4Box("mdia", mp4Box("hdlr", []byte("\x00\x00\x00\x00\x00\x00\x00\x00vide")), deep))
+
+	tests := []struct {
+		name   string
+		file   []byte
+		want   videoInfo
+		width  int
+		height int
+	}{
+		{
+			name:  "moov after ftyp",
+			file:  bytes.Join([][]byte{ftyp, mp4Box("moov", mp4Header("mvhd", 1000, 10010), video), mp4Box("mdat", make([]byte, 64))}, nil),
+			want:  videoInfo{Duration: 10.01, FrameRate: 29.97, Codec: "avc1"},
+			width: 1920, height: 1080,
+		},
+		{
+			name: "large mdat before moov, audio track first",
+			file: bytes.Join([][]byte{ftyp, mp4LargeBox("mdat", make([]byte, 256)),
+				mp4Box("moov", mp4Header("mvhd", 600, 6006), audio,
+					mp4Trak("vide", "hvc1", 3840, 2160, 3840, 2160, 60000, 600600, 600))}, nil),
+			want:  videoInfo{Duration: 10.01, FrameRate: 59.94, Codec: "hvc1"},
+			width: 3840, height: 2160,
+		},
+		{
+			name: "no display size, coded size used",
+			file: mp4Box("moov", mp4Header("mvhd", 1000, 2000),
+				mp4Trak("vide", "mp4v", 0, 0, 640, 480, 25, 50, 50)),
+			want:  videoInfo{Duration: 2, FrameRate: 25, Codec: "mp4v"},
+			width: 640, height: 480,
+		},
+		{
+			name: "audio only",
+			file: mp4Box("moov", mp4Header("mvhd", 1000, 5000), audio),
+			want: videoInfo{Duration: 5},
+		},
+		{
+			name: "boxes nested too deep",
+			file: mp4Box("moov", mp4Header("mvhd", 1000, 1000), deepTrak),
+			want: videoInfo{Duration: 1},
+		},
+		{
+			name: "no moov",
+			file: bytes.Join([][]byte{ftyp, mp4Box("mdat", make([]byte, 16))}, nil),
+		},
+		{
+			name: "truncated box",
+			file: ftyp[:6],
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, w, h := readVideoInfo(bytes.NewReader(tt.file), ".MP4")
+			if got != tt.want || w != tt.width || h != tt.height {
+				t.Errorf("readVideoInfo = %+v %dx%d, want %+v %dx%d", got, w, h, tt.want, tt.width, tt.height)
+			}
+		})
+	}
+}
+
+// =============================================================================
+// Matroska Fixtures
+// =============================================================================
+
+// ebmlUnknownSize is an 8-byte EBML size with every value bit set.
+var ebmlUnknownSize = []byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+
+// ebmlSize encodes n as the shortest EBML size that isn't the unknown size.
+func ebmlSize(n int) []byte {
+	for width := 1; width <= 8; width++ {
+		if uint64(n) < 1<<(7*width)-1 {
+			b := make([]byte, width)
+			v := uint64(n) | 1<<(7*width)
+			for i := width - 1; i >= 0; i-- {
+				b[i] = byte(v)
+				v >>= 8
+			}
+			return b
+		}
+	}
+	panic("EBML size too large")
+}
+
+// ebmlIDBytes encodes an element ID, which keeps its length marker.
+func ebmlIDBytes(id uint64) []byte {
+	b := binary.BigEndian.AppendUint64(nil, id)
+	return bytes.TrimLeft(b, "\x00")
+}
+
+// ebmlElement builds an element holding the concatenated parts.
+func ebmlElement(id uint64, parts ...[]byte) []byte {
+	payload := bytes.Join(parts, nil)
+	b := append(ebmlIDBytes(id), ebmlSize(len(payload))...)
+	return append(b, payload...)
+}
+
+// ebmlUintElement builds an unsigned integer element.
+func ebmlUintElement(id, v uint64) []byte {
+	b := bytes.TrimLeft(binary.BigEndian.AppendUint64(nil, v), "\x00")
+	if len(b) == 0 {
+		b = []byte{0}
+	}
+	return ebmlElement(id, b)
+}
+
+// ebmlFloatElement builds an 8-byte float element.
+func ebmlFloatElement(id uint64, v float64) []byte {
+	return ebmlElement(id, binary.BigEndian.AppendUint64(nil, math.Float64bits(v)))
+}
+
+// mkvFile builds a Matroska file whose Segment holds children, with a
+// known or unknown size.
+func mkvFile(unknownSize bool, children ...[]byte) []byte {
+	header := ebmlElement(ebmlIDHeader, ebmlElement(0x4282, []byte("matroska")))
+	body := bytes.Join(children, nil)
+	segment := ebmlIDBytes(mkvIDSegment)
+	if unknownSize {
+		segment = append(segment, ebmlUnknownSize...)
+	} else {
+		segment = append(segment, ebmlSize(len(body))...)
+	}
+	return bytes.Join([][]byte{header, segment, body}, nil)
+}
+
+// mkvTrack builds a TrackEntry; width and height are only written for
+// video tracks.
+func mkvTrack(trackType uint64, codec string, frameNs uint64, width, height uint64) []byte {
+	parts := [][]byte{
+		ebmlUintElement(mkvIDTrackType, trackType),
+		ebmlElement(mkvIDCodecID, []byte(codec)),
+	}
+	if frameNs > 0 {
+		parts = append(parts, ebmlUintElement(mkvIDDefaultDuration, frameNs))
+	}
+	if trackType == mkvTrackTypeVideo {
+		parts = append(parts, ebmlElement(mkvIDVideo,
+			ebmlUintElement(mkvIDPixelWidth, width),
+			ebmlUintElement(mkvIDPixelHeight, height)))
+	}
+	return ebmlElement(mkvIDTrackEntry, parts...)
+}
+
+func TestReadMatroskaInfo(t *testing.T) {
+	seekHead := ebmlElement(0x114D9B74, make([]byte, 32))
+	info := ebmlElement(mkvIDInfo,
+		ebmlUintElement(mkvIDTimecodeScale, 1000000),
+		ebmlFloatElement(mkvIDDuration, 12345))
+	tracks := ebmlElement(mkvIDTracks,
+		mkvTrack(2, "A_OPUS", 0, 0, 0),
+		mkvTrack(mkvTrackTypeVideo, "V_VP9", 16683350, 3840, 2160))
+	cluster := ebmlElement(mkvIDCluster, make([]byte, 64))
+
+	tests := []struct {
+		name   string
+		file   []byte
+		want   videoInfo
+		width  int
+		height int
+	}{
+		{
+			name:  "known segment size",
+			file:  mkvFile(false, seekHead, info, tracks, cluster),
+			want:  videoInfo{Duration: 12.345, FrameRate: 59.94, Codec: "vp09"},
+			width: 3840, height: 2160,
+		},
+		{
+			name:  "unknown segment size",
+			file:  mkvFile(true, seekHead, info, tracks, cluster),
+			want:  videoInfo{Duration: 12.345, FrameRate: 59.94, Codec: "vp09"},
+			width: 3840, height: 2160,
+		},
+		{
+			name: "custom timecode scale, unmapped codec",
+			file: mkvFile(false,
+				ebmlElement(mkvIDInfo, ebmlUintElement(mkvIDTimecodeScale, 1000), ebmlFloatElement(mkvIDDuration, 2500000)),
+				ebmlElement(mkvIDTracks, mkvTrack(mkvTrackTypeVideo, "V_PRORES", 40000000, 1920, 1080))),
+			want:  videoInfo{Duration: 2.5, FrameRate: 25, Codec: "V_PRORES"},
+			width: 1920, height: 1080,
+		},
+		{
+			name: "tracks after the first cluster are not read",
+			file: mkvFile(true, info, cluster, tracks),
+			want: videoInfo{Duration: 12.345},
+		},
+		{
+			name: "not Matroska",
+			file: mp4Box("ftyp", []byte("isom")),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, w, h := readVideoInfo(bytes.NewReader(tt.file), ".mkv")
+			if got != tt.want || w != tt.width || h != tt.height {
+				t.Errorf("readVideoInfo = %+v %dx%d, want %+v %dx%d", got, w, h, tt.want, tt.width, tt.height)
+			}
+		})
+	}
+}
+
+func TestDecodeEBMLVint(t *testing.T) {
+	tests := []struct {
+		b           []byte
+		keepMarker  bool
+		want        uint64
+		wantUnknown bool
+	}{
+		{[]byte{0x81}, false, 1, false},
+		{[]byte{0xFE}, false, 126, false},
+		{[]byte{0xFF}, false, 127, true},
+		{[]byte{0x40, 0x02}, false, 2, false},
+		{[]byte{0x7F, 0xFF}, false, 0x3FFF, true},
+		{[]byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFE}, false, 1<<56 - 2, false},
+		{ebmlUnknownSize, false, 1<<56 - 1, true},
+		{[]byte{0xFF}, true, 0xFF, false},
+		{[]byte{0x1A, 0x45, 0xDF, 0xA3}, true, ebmlIDHeader, false},
+	}
+
+	for _, tt := range tests {
+		got, unknown := decodeEBMLVint(tt.b, tt.keepMarker)
+		if got != tt.want || unknown != tt.wantUnknown {
+			t.Errorf("decodeEBMLVint(% x, %v) = %#x, %v; want %#x, %v",
+				tt.b, tt.keepMarker, got, unknown, tt.want, tt.wantUnknown)
+		}
+	}
+}